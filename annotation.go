@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/*
+annotation file format (one directive per line, '#' starts line comment)
+
+label   ADDR NAME            name a code or data address
+sub     ADDR NAME            name a subroutine
+data    START END [NAME]     treat [START, END] as data
+code    START END            treat [START, END] as code
+comment ADDR TEXT...         attach comment to the address
+*/
+
+type RegionKind uint8
+
+const (
+	REGION_CODE RegionKind = iota
+	REGION_DATA
+)
+
+type AnnotationRegion struct {
+	kind  RegionKind
+	start uint16
+	end   uint16 // inclusive
+}
+
+type Annotation struct {
+	labels      map[uint16]string
+	subroutines map[uint16]bool
+	comments    map[uint16][]string
+	regions     []AnnotationRegion // later region has priority
+}
+
+func NewAnnotation() *Annotation {
+	return &Annotation{
+		labels:      make(map[uint16]string),
+		subroutines: make(map[uint16]bool),
+		comments:    make(map[uint16][]string),
+	}
+}
+
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// generatedLabelPattern matches label names generated by disassembler
+var generatedLabelPattern = regexp.MustCompile(`^(label|subroutine)[0-9]+$`)
+
+func parseAnnotationAddr(s string) (uint16, error) {
+	v, err := strconv.ParseUint(s, 0, 16)
+	if err != nil || v >= Chip8RAMSize {
+		return 0, fmt.Errorf("invalid address: %s", s)
+	}
+	return uint16(v), nil
+}
+
+func (a *Annotation) setLabel(addr uint16, name string) error {
+	if !identifierPattern.MatchString(name) {
+		return fmt.Errorf("invalid name: %s", name)
+	}
+	if parseOperand(name, 0).kind != OPERAND_ADDR { // disassembled source cannot be assembled
+		return fmt.Errorf("name is register or operand keyword: %s", name)
+	}
+	if generatedLabelPattern.MatchString(name) || (name == "start" && addr != Chip8ProgStartAddr) {
+		return fmt.Errorf("name is reserved by disassembler: %s", name)
+	}
+	for otherAddr, other := range a.labels {
+		if other == name && otherAddr != addr {
+			return fmt.Errorf("name is already defined at 0x%03X: %s", otherAddr, name)
+		}
+	}
+	a.labels[addr] = name
+	return nil
+}
+
+func (a *Annotation) parseLine(line string) error {
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return fmt.Errorf("require address: %s", line)
+	}
+	addr, err := parseAnnotationAddr(fields[1])
+	if err != nil {
+		return err
+	}
+	switch fields[0] {
+	case "label", "sub":
+		if len(fields) != 3 {
+			return fmt.Errorf("usage: %s ADDR NAME", fields[0])
+		}
+		if fields[0] == "sub" {
+			a.subroutines[addr] = true
+		}
+		return a.setLabel(addr, fields[2])
+	case "data", "code":
+		if fields[0] == "data" && (len(fields) < 3 || len(fields) > 4) {
+			return fmt.Errorf("usage: data START END [NAME]")
+		}
+		if fields[0] == "code" && len(fields) != 3 {
+			return fmt.Errorf("usage: code START END")
+		}
+		end, err := parseAnnotationAddr(fields[2])
+		if err != nil {
+			return err
+		}
+		if addr > end {
+			return fmt.Errorf("empty region: %s-%s", fields[1], fields[2])
+		}
+		kind := REGION_CODE
+		if fields[0] == "data" {
+			kind = REGION_DATA
+		}
+		a.regions = append(a.regions, AnnotationRegion{kind: kind, start: addr, end: end})
+		if len(fields) == 4 {
+			return a.setLabel(addr, fields[3])
+		}
+		return nil
+	case "comment":
+		text := strings.TrimSpace(line[len(fields[0]):])
+		text = strings.TrimSpace(text[len(fields[1]):])
+		a.comments[addr] = append(a.comments[addr], text)
+		return nil
+	default:
+		return fmt.Errorf("unknown directive: %s", fields[0])
+	}
+}
+
+// LoadAnnotation parse annotation file
+func LoadAnnotation(reader io.Reader) (*Annotation, error) {
+	annotation := NewAnnotation()
+	scanner := bufio.NewScanner(reader)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := annotation.parseLine(line); err != nil {
+			return nil, fmt.Errorf("annotation line %d: %v", lineNum, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return annotation, nil
+}
+
+func (a *Annotation) isData(addr uint16) bool {
+	if a == nil {
+		return false
+	}
+	for i := len(a.regions) - 1; i >= 0; i-- {
+		if r := a.regions[i]; addr >= r.start && addr <= r.end {
+			return r.kind == REGION_DATA
+		}
+	}
+	return false
+}
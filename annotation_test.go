@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestAnnotationReservedName(t *testing.T) {
+	for _, name := range []string{"b", "F", "k", "i", "dt", "ST", "V1", "va", "label0", "subroutine12", "start"} {
+		if _, err := LoadAnnotation(strings.NewReader("label 0x204 " + name)); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+	for _, name := range []string{"start", "loop", "big", "label", "labels1", "v16"} {
+		if _, err := LoadAnnotation(strings.NewReader("label 0x200 " + name)); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestAnnotationRoundTrip(t *testing.T) {
+	rom := []byte{0x12, 0x04, 0x22, 0x06, 0x12, 0x00, 0x00, 0xEE}
+	annotation, err := LoadAnnotation(strings.NewReader("label 0x204 back\nsub 0x206 draw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var source, output bytes.Buffer
+	if err := Disassemble(bytes.NewReader(rom), &source, DisasmOption{Annotation: annotation}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Assemble(bytes.NewReader(source.Bytes()), &output); err != nil {
+		t.Fatalf("assemble error: %v\n%s", err, source.String())
+	}
+	if !bytes.Equal(output.Bytes(), rom) {
+		t.Errorf("got % x, want % x", output.Bytes(), rom)
+	}
+}
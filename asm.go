@@ -0,0 +1,932 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const Chip8ProgMaxSize = Chip8RAMSize - Chip8ProgStartAddr
+
+type asmOperand struct {
+	kind OperandKind // OPERAND_REG, OPERAND_ADDR (expression) or keyword operand
+	reg  uint8
+	expr string // source text
+	col  int    // 1-based column
+}
+
+type asmStatement struct {
+	file      string
+	line      int
+	source    string // source line
+	scope     string // enclosing global label for local label reference
+	labels    []string
+	labelCols []int
+	mnemonic  string // upper case
+	col       int    // column of mnemonic
+	operands  []asmOperand
+	rawWord   bool   // instruction word written as number
+	data      []byte // INCBIN content
+	bytes     []byte // encoded bytes
+	addr      uint16
+	size      int
+}
+
+type asmSection struct {
+	name       string
+	stmt       *asmStatement // SECTION directive
+	fixed      bool
+	addr       uint16
+	statements []*asmStatement
+	size       int
+}
+
+type asmConst struct {
+	operand asmOperand
+	stmt    *asmStatement
+	state   int // 0: not evaluated, 1: evaluating, 2: evaluated, 3: failed
+	value   int
+}
+
+type Assembler struct {
+	sections     []*asmSection
+	current      *asmSection
+	labels       map[string]uint16
+	consts       map[string]*asmConst
+	includeStack []string
+	scope        string
+	statements   []*asmStatement // all parsed statements including directives
+	diagnostics  Diagnostics
+}
+
+var (
+	labelDefPattern = regexp.MustCompile(`^\s*(\.?[A-Za-z_][A-Za-z0-9_]*)\s*:`)
+	registerPattern = regexp.MustCompile(`^[Vv]([0-9]|1[0-5]|[A-Fa-f])$`)
+	numberPattern   = regexp.MustCompile(`^@?[0-9]`)
+	symbolPattern   = regexp.MustCompile(`^\.?[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+func NewAssembler() *Assembler {
+	a := &Assembler{
+		labels: make(map[string]uint16),
+		consts: make(map[string]*asmConst),
+	}
+	a.current = &asmSection{name: "default"}
+	a.sections = append(a.sections, a.current)
+	return a
+}
+
+// errorAt create diagnostic pointing to the column
+func (s *asmStatement) errorAt(col int, length int, format string, args ...any) *Diagnostic {
+	return &Diagnostic{
+		File:     s.file,
+		Line:     s.line,
+		Column:   col,
+		Length:   length,
+		Severity: SEVERITY_ERROR,
+		Message:  fmt.Sprintf(format, args...),
+		source:   s.source,
+	}
+}
+
+// errorf create diagnostic pointing to the mnemonic
+func (s *asmStatement) errorf(format string, args ...any) *Diagnostic {
+	return s.errorAt(s.col, len(s.mnemonic), format, args...)
+}
+
+func (s *asmStatement) operandErrorf(operand asmOperand, format string, args ...any) *Diagnostic {
+	return s.errorAt(operand.col, len(operand.expr), format, args...)
+}
+
+func (s *asmStatement) labelErrorf(index int, format string, args ...any) *Diagnostic {
+	label := s.labels[index]
+	if dot := strings.IndexByte(label, '.'); dot > 0 { // local label is written without scope
+		label = label[dot:]
+	}
+	return s.errorAt(s.labelCols[index], len(label), format, args...)
+}
+
+func (a *Assembler) report(err error) {
+	if diagnostic, ok := err.(*Diagnostic); ok {
+		a.diagnostics = append(a.diagnostics, diagnostic)
+	} else {
+		a.diagnostics = append(a.diagnostics, &Diagnostic{Severity: SEVERITY_ERROR, Message: err.Error()})
+	}
+}
+
+// Diagnostics returns errors and warnings reported so far
+func (a *Assembler) Diagnostics() Diagnostics {
+	return a.diagnostics
+}
+
+func (a *Assembler) checkDiagnostics() error {
+	if a.diagnostics.HasError() {
+		return a.diagnostics
+	}
+	return nil
+}
+
+func parseRegister(s string) (uint8, bool) {
+	m := registerPattern.FindStringSubmatch(s)
+	if m == nil {
+		return 0, false
+	}
+	v, _ := strconv.ParseUint(m[1], 10, 8)
+	if len(m[1]) == 1 {
+		v, _ = strconv.ParseUint(m[1], 16, 8)
+	}
+	return uint8(v), true
+}
+
+func parseOperand(s string, col int) asmOperand {
+	if reg, ok := parseRegister(s); ok {
+		return asmOperand{kind: OPERAND_REG, reg: reg, expr: s, col: col}
+	}
+	for kind, keyword := range operandKeywords {
+		if kind != OPERAND_V0 && strings.EqualFold(s, keyword) {
+			return asmOperand{kind: kind, expr: s, col: col}
+		}
+	}
+	return asmOperand{kind: OPERAND_ADDR, expr: s, col: col}
+}
+
+// qualify local label (.name) with enclosing global label
+func qualifyLabel(scope string, name string) string {
+	if strings.HasPrefix(name, ".") {
+		return scope + name
+	}
+	return name
+}
+
+func unquote(stmt *asmStatement) (string, error) {
+	if len(stmt.operands) != 1 {
+		return "", stmt.errorf("%s requires one file path", stmt.mnemonic)
+	}
+	path, err := strconv.Unquote(stmt.operands[0].expr)
+	if err != nil {
+		return "", stmt.operandErrorf(stmt.operands[0], "file path must be quoted: %s", stmt.operands[0].expr)
+	}
+	return path, nil
+}
+
+// stripComment remove comment starting with ';'. ';' in quoted file path is kept
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '`':
+			quote = c
+		case c == ';':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// skipSpace returns index of first non space character from pos
+func skipSpace(line string, pos int) int {
+	for pos < len(line) && (line[pos] == ' ' || line[pos] == '\t') {
+		pos++
+	}
+	return pos
+}
+
+func (a *Assembler) parseLine(file string, lineNum int, source string) (*asmStatement, error) {
+	line := stripComment(source)
+	stmt := &asmStatement{file: file, line: lineNum, source: source}
+	pos := 0
+	for {
+		m := labelDefPattern.FindStringSubmatchIndex(line[pos:])
+		if m == nil {
+			break
+		}
+		label := line[pos+m[2] : pos+m[3]]
+		col := pos + m[2] + 1
+		if !strings.HasPrefix(label, ".") {
+			a.scope = label
+		} else if a.scope == "" {
+			return nil, stmt.errorAt(col, len(label), "local label requires preceding global label: %s", label)
+		}
+		stmt.labels = append(stmt.labels, qualifyLabel(a.scope, label))
+		stmt.labelCols = append(stmt.labelCols, col)
+		pos += m[1]
+	}
+	stmt.scope = a.scope
+	pos = skipSpace(line, pos)
+	if pos == len(strings.TrimRight(line, " \t")) {
+		return stmt, nil
+	}
+
+	end := pos
+	for end < len(line) && line[end] != ' ' && line[end] != '\t' {
+		end++
+	}
+	mnemonic := line[pos:end]
+	stmt.mnemonic = strings.ToUpper(mnemonic)
+	stmt.col = pos + 1
+	operandPos := end
+	if numberPattern.MatchString(mnemonic) { // raw word
+		stmt.mnemonic = "DW"
+		stmt.rawWord = true
+		operandPos = pos
+	} else if next := skipSpace(line, end); strings.HasPrefix(strings.ToUpper(line[next:]), "EQU") &&
+		(next+3 == len(line) || line[next+3] == ' ' || line[next+3] == '\t') { // NAME EQU value
+		stmt.mnemonic = "EQU"
+		stmt.col = next + 1
+		stmt.operands = append(stmt.operands, asmOperand{kind: OPERAND_ADDR, expr: mnemonic, col: pos + 1})
+		operandPos = next + 3
+	}
+
+	if rest := strings.TrimSpace(line[operandPos:]); rest != "" {
+		for _, field := range strings.Split(line[operandPos:], ",") {
+			start := skipSpace(field, 0)
+			operand := strings.TrimSpace(field)
+			col := operandPos + start + 1
+			if operand == "" {
+				return nil, stmt.errorAt(col, 1, "empty operand")
+			}
+			stmt.operands = append(stmt.operands, parseOperand(operand, col))
+			operandPos += len(field) + 1
+		}
+	}
+	return stmt, nil
+}
+
+func (a *Assembler) defineConst(stmt *asmStatement) error {
+	if len(stmt.operands) != 2 || !symbolPattern.MatchString(stmt.operands[0].expr) ||
+		strings.HasPrefix(stmt.operands[0].expr, ".") {
+		return stmt.errorf("usage: NAME EQU value")
+	}
+	name := stmt.operands[0].expr
+	if _, ok := a.consts[name]; ok {
+		return stmt.operandErrorf(stmt.operands[0], "duplicated constant: %s", name)
+	}
+	a.consts[name] = &asmConst{operand: stmt.operands[1], stmt: stmt}
+	return nil
+}
+
+func (a *Assembler) openSection(stmt *asmStatement) error {
+	if len(stmt.operands) < 1 || len(stmt.operands) > 2 || !symbolPattern.MatchString(stmt.operands[0].expr) {
+		return stmt.errorf("usage: SECTION name[, address]")
+	}
+	name := stmt.operands[0].expr
+	fixed := len(stmt.operands) == 2
+	var addr uint16
+	if fixed {
+		v, err := a.evalExpr(stmt, stmt.operands[1], Chip8RAMSize-1)
+		if err != nil {
+			return err
+		}
+		if v < Chip8ProgStartAddr {
+			return stmt.operandErrorf(stmt.operands[1],
+				"section address must be greater than or equal to 0x%03X", Chip8ProgStartAddr)
+		}
+		addr = v
+	}
+	for _, section := range a.sections {
+		if section.name == name {
+			if fixed && (!section.fixed || section.addr != addr) {
+				return stmt.operandErrorf(stmt.operands[0], "section address mismatch: %s", name)
+			}
+			a.current = section
+			return nil
+		}
+	}
+	a.current = &asmSection{name: name, stmt: stmt, fixed: fixed, addr: addr}
+	a.sections = append(a.sections, a.current)
+	return nil
+}
+
+// Parse parse assembly source. included files are resolved from dir.
+// syntax errors are reported to diagnostics, and error is returned only when source cannot be read
+func (a *Assembler) Parse(name string, reader io.Reader, dir string) error {
+	a.includeStack = append(a.includeStack, name)
+	defer func() { a.includeStack = a.includeStack[:len(a.includeStack)-1] }()
+	a.scope = "" // local label scope does not across files
+
+	scanner := bufio.NewScanner(reader)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		stmt, err := a.parseLine(name, lineNum, scanner.Text())
+		if err != nil {
+			a.report(err)
+			continue
+		}
+		a.statements = append(a.statements, stmt)
+		if err := a.parseStatement(stmt, dir); err != nil {
+			a.report(err)
+		}
+	}
+	return scanner.Err()
+}
+
+func (a *Assembler) parseStatement(stmt *asmStatement, dir string) error {
+	switch stmt.mnemonic {
+	case "INCLUDE":
+		path, err := unquote(stmt)
+		if err != nil {
+			return err
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		for _, included := range a.includeStack {
+			if included == path {
+				return stmt.operandErrorf(stmt.operands[0], "recursive include: %s", path)
+			}
+		}
+		if len(stmt.labels) > 0 { // labels point to start of included content
+			stmt.mnemonic = ""
+			a.current.statements = append(a.current.statements, stmt)
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return stmt.operandErrorf(stmt.operands[0], "%v", err)
+		}
+		defer file.Close()
+		scope, section := a.scope, a.current // included file does not change them
+		err = a.Parse(path, file, filepath.Dir(path))
+		a.scope, a.current = scope, section
+		if err != nil {
+			return stmt.operandErrorf(stmt.operands[0], "%v", err)
+		}
+		return nil
+	case "INCBIN":
+		path, err := unquote(stmt)
+		if err != nil {
+			return err
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		if stmt.data, err = os.ReadFile(path); err != nil {
+			return stmt.operandErrorf(stmt.operands[0], "%v", err)
+		}
+	case "EQU":
+		if len(stmt.labels) > 0 {
+			return stmt.errorf("EQU cannot have label")
+		}
+		return a.defineConst(stmt)
+	case "SECTION":
+		if len(stmt.labels) > 0 {
+			return stmt.errorf("SECTION cannot have label")
+		}
+		return a.openSection(stmt)
+	}
+	if stmt.mnemonic != "" || len(stmt.labels) > 0 {
+		a.current.statements = append(a.current.statements, stmt)
+	}
+	return nil
+}
+
+func (a *Assembler) ParseFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return a.Parse(path, file, filepath.Dir(path))
+}
+
+func (s *asmStatement) computeSize() error {
+	switch s.mnemonic {
+	case "":
+		s.size = 0
+	case "DB":
+		s.size = len(s.operands)
+	case "DW":
+		s.size = 2 * len(s.operands)
+	case "INCBIN":
+		s.size = len(s.data)
+		return nil
+	default:
+		s.size = 2
+		return nil
+	}
+	for _, operand := range s.operands {
+		if operand.kind != OPERAND_ADDR {
+			return s.operandErrorf(operand, "%s requires constant operands", s.mnemonic)
+		}
+	}
+	return nil
+}
+
+// isData reports whether the statement emits data rather than instruction
+func (s *asmStatement) isData() bool {
+	switch s.mnemonic {
+	case "DB", "INCBIN":
+		return true
+	case "DW":
+		return !s.rawWord
+	}
+	return false
+}
+
+func (s *asmSection) end() int {
+	return int(s.addr) + s.size
+}
+
+func (s *asmSection) overlap(other *asmSection) bool {
+	return int(s.addr) < other.end() && int(other.addr) < s.end()
+}
+
+func (s *asmSection) errorf(format string, args ...any) *Diagnostic {
+	if s.stmt == nil {
+		return &Diagnostic{Severity: SEVERITY_ERROR, Message: fmt.Sprintf(format, args...)}
+	}
+	return s.stmt.operandErrorf(s.stmt.operands[0], format, args...)
+}
+
+// Link lay out sections and resolve label addresses.
+// fixed sections are placed at specified address, then other sections are placed at first fit address
+func (a *Assembler) Link() error {
+	var placed []*asmSection
+	for _, section := range a.sections {
+		section.size = 0
+		for _, stmt := range section.statements {
+			if err := stmt.computeSize(); err != nil {
+				a.report(err)
+			}
+			section.size += stmt.size
+		}
+		if !section.fixed || section.size == 0 {
+			continue
+		}
+		for _, other := range placed {
+			if section.overlap(other) {
+				a.report(section.errorf("section `%s' (0x%03X-0x%03X) overlaps section `%s' (0x%03X-0x%03X)",
+					section.name, section.addr, section.end()-1, other.name, other.addr, other.end()-1))
+			}
+		}
+		placed = append(placed, section)
+	}
+	for _, section := range a.sections {
+		if section.fixed || section.size == 0 {
+			continue
+		}
+		addr := Chip8ProgStartAddr
+		for retry := true; retry; {
+			retry = false
+			section.addr = uint16(addr)
+			for _, other := range placed {
+				if section.overlap(other) {
+					addr = other.end()
+					retry = true
+				}
+			}
+		}
+		placed = append(placed, section)
+	}
+
+	for _, section := range a.sections {
+		if section.end() > Chip8RAMSize {
+			a.report(section.errorf("section `%s' exceeds address 0x%03X (program size: %d bytes, max: %d bytes)",
+				section.name, Chip8RAMSize-1, a.Size(), Chip8ProgMaxSize))
+		}
+		addr := section.addr
+		for _, stmt := range section.statements {
+			for i, label := range stmt.labels {
+				if _, ok := a.labels[label]; ok {
+					a.report(stmt.labelErrorf(i, "duplicated label: %s", label))
+					continue
+				}
+				if _, ok := a.consts[label]; ok {
+					a.report(stmt.labelErrorf(i, "label conflicts with constant: %s", label))
+					continue
+				}
+				a.labels[label] = addr
+			}
+			stmt.addr = addr
+			addr += uint16(stmt.size)
+		}
+	}
+	return a.checkDiagnostics()
+}
+
+// Size returns program size including gaps between sections
+func (a *Assembler) Size() int {
+	end := Chip8ProgStartAddr
+	for _, section := range a.sections {
+		if section.size > 0 && section.end() > end {
+			end = section.end()
+		}
+	}
+	return end - Chip8ProgStartAddr
+}
+
+// WriteLinkMap write section layout and program size
+func (a *Assembler) WriteLinkMap(writer io.Writer) error {
+	for _, section := range a.sortedSections() {
+		if section.size == 0 {
+			continue
+		}
+		_, _ = fmt.Fprintf(writer, "0x%03X-0x%03X  %5d bytes  %s\n", section.addr, section.end()-1, section.size, section.name)
+	}
+	_, err := fmt.Fprintf(writer, "total: %d / %d bytes\n", a.Size(), Chip8ProgMaxSize)
+	return err
+}
+
+func (a *Assembler) evalSymbol(stmt *asmStatement, operand asmOperand, name string) (int, error) {
+	name = qualifyLabel(stmt.scope, name)
+	if addr, ok := a.labels[name]; ok {
+		return int(addr), nil
+	}
+	c, ok := a.consts[name]
+	if !ok {
+		return 0, stmt.operandErrorf(operand, "undefined label: %s", name)
+	}
+	switch c.state {
+	case 1:
+		return 0, stmt.operandErrorf(operand, "recursive constant: %s", name)
+	case 3: // error of the definition is already reported
+		return 0, stmt.operandErrorf(operand, "invalid constant: %s", name)
+	case 0:
+		c.state = 1
+		v, err := a.evalTerms(c.stmt, c.operand)
+		if err != nil {
+			c.state = 3
+			return 0, err
+		}
+		c.value = v
+		c.state = 2
+	}
+	return c.value, nil
+}
+
+// evalTerms evaluate expression composed of numbers, labels and constants joined by + or -
+func (a *Assembler) evalTerms(stmt *asmStatement, operand asmOperand) (int, error) {
+	expr := operand.expr
+	result := 0
+	sign := 1
+	term := ""
+	flush := func() error {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			return stmt.operandErrorf(operand, "invalid expression: %s", expr)
+		}
+		var v int
+		if numberPattern.MatchString(term) {
+			n, err := strconv.ParseUint(strings.TrimPrefix(term, "@"), 0, 16)
+			if err != nil {
+				return stmt.operandErrorf(operand, "invalid number: %s", term)
+			}
+			v = int(n)
+		} else if symbolPattern.MatchString(term) {
+			var err error
+			if v, err = a.evalSymbol(stmt, operand, term); err != nil {
+				return err
+			}
+		} else {
+			return stmt.operandErrorf(operand, "invalid expression: %s", expr)
+		}
+		result += sign * v
+		term = ""
+		return nil
+	}
+	for i, r := range expr {
+		if (r == '+' || r == '-') && (i > 0 || r == '+') {
+			if err := flush(); err != nil {
+				return 0, err
+			}
+			sign = map[rune]int{'+': 1, '-': -1}[r]
+		} else if r == '-' {
+			sign = -1
+		} else {
+			term += string(r)
+		}
+	}
+	if err := flush(); err != nil {
+		return 0, err
+	}
+	return result, nil
+}
+
+// symbolTerms returns names of labels and constants in expression
+func symbolTerms(expr string) []string {
+	var names []string
+	for _, term := range strings.FieldsFunc(expr, func(r rune) bool { return r == '+' || r == '-' }) {
+		if term = strings.TrimSpace(term); symbolPattern.MatchString(term) {
+			names = append(names, term)
+		}
+	}
+	return names
+}
+
+var operandRangeNames = map[uint16]string{
+	0xF:    "nibble",
+	0xFF:   "byte",
+	0xFFF:  "address",
+	0xFFFF: "word",
+}
+
+func (a *Assembler) evalExpr(stmt *asmStatement, operand asmOperand, limit uint16) (uint16, error) {
+	v, err := a.evalTerms(stmt, operand)
+	if err != nil {
+		return 0, err
+	}
+	if v < 0 || v > int(limit) {
+		name, ok := operandRangeNames[limit]
+		if !ok {
+			name = "value"
+		}
+		if numberPattern.MatchString(operand.expr) {
+			return 0, stmt.operandErrorf(operand, "%s out of range (0x0-0x%X): %s", name, limit, operand.expr)
+		}
+		return 0, stmt.operandErrorf(operand, "%s out of range (0x0-0x%X): %s = 0x%X", name, limit, operand.expr, v)
+	}
+	return uint16(v), nil
+}
+
+var operandLimits = map[OperandKind]uint16{
+	OPERAND_ADDR:   0xFFF,
+	OPERAND_BYTE:   0xFF,
+	OPERAND_NIBBLE: 0xF,
+}
+
+var operandKindNames = map[OperandKind]string{
+	OPERAND_REG:    "register",
+	OPERAND_V0:     "V0",
+	OPERAND_ADDR:   "address",
+	OPERAND_BYTE:   "byte constant",
+	OPERAND_NIBBLE: "nibble constant",
+	OPERAND_I:      "I",
+	OPERAND_IND_I:  "[I]",
+	OPERAND_DT:     "DT",
+	OPERAND_ST:     "ST",
+	OPERAND_K:      "K",
+	OPERAND_F:      "F",
+	OPERAND_B:      "B",
+}
+
+func matchOperand(kind OperandKind, operand asmOperand) bool {
+	switch kind {
+	case OPERAND_REG:
+		return operand.kind == OPERAND_REG
+	case OPERAND_V0:
+		return operand.kind == OPERAND_REG && operand.reg == 0
+	case OPERAND_ADDR, OPERAND_BYTE, OPERAND_NIBBLE:
+		return operand.kind == OPERAND_ADDR
+	}
+	return operand.kind == kind
+}
+
+func matchOperandForm(form []OperandKind, operands []asmOperand) bool {
+	if len(form) != len(operands) {
+		return false
+	}
+	for i, kind := range form {
+		if !matchOperand(kind, operands[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// lookup instruction type by mnemonic and operand form
+func lookupInstruction(mnemonic string, operands []asmOperand) (InstructionType, bool, bool) {
+	foundMnemonic := false
+	for op := OP_0NNN; op < OP_INVALID; op++ {
+		if InstructionTypeNames[op] != mnemonic {
+			continue
+		}
+		foundMnemonic = true
+		if matchOperandForm(InstructionOperandForms[op], operands) {
+			return op, true, true
+		}
+	}
+	return OP_INVALID, foundMnemonic, false
+}
+
+// operandFormError explains why operands do not match any form of the mnemonic.
+// candidate forms are narrowed down by operands from left, and the first operand matching none of them is reported
+func operandFormError(stmt *asmStatement) *Diagnostic {
+	var candidates [][]OperandKind
+	counts := map[int]bool{}
+	for op := OP_0NNN; op < OP_INVALID; op++ {
+		if InstructionTypeNames[op] == stmt.mnemonic {
+			form := InstructionOperandForms[op]
+			counts[len(form)] = true
+			if len(form) == len(stmt.operands) {
+				candidates = append(candidates, form)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		var expected []string
+		for n := 0; n <= 3; n++ {
+			if counts[n] {
+				expected = append(expected, strconv.Itoa(n))
+			}
+		}
+		return stmt.errorf("%s requires %s operand(s), but got %d",
+			stmt.mnemonic, strings.Join(expected, " or "), len(stmt.operands))
+	}
+	for i, operand := range stmt.operands {
+		var matched [][]OperandKind
+		var expected []string
+		seen := map[string]bool{}
+		for _, form := range candidates {
+			if matchOperand(form[i], operand) {
+				matched = append(matched, form)
+			}
+			if name := operandKindNames[form[i]]; !seen[name] {
+				seen[name] = true
+				expected = append(expected, name)
+			}
+		}
+		if len(matched) == 0 {
+			got := "constant"
+			if operand.kind != OPERAND_ADDR {
+				got = operandKindNames[operand.kind]
+			}
+			return stmt.operandErrorf(operand, "%s expects %s, but got %s `%s'",
+				stmt.mnemonic, strings.Join(expected, " or "), got, operand.expr)
+		}
+		candidates = matched
+	}
+	return stmt.errorf("invalid operands for %s", stmt.mnemonic)
+}
+
+// dataAt returns statement emitting data at the address
+func (a *Assembler) dataAt(addr uint16) *asmStatement {
+	for _, section := range a.sections {
+		if int(addr) < int(section.addr) || int(addr) >= section.end() {
+			continue
+		}
+		for _, stmt := range section.statements {
+			if stmt.isData() && addr >= stmt.addr && int(addr) < int(stmt.addr)+stmt.size {
+				return stmt
+			}
+		}
+	}
+	return nil
+}
+
+func (a *Assembler) encode(stmt *asmStatement) ([]byte, error) {
+	switch stmt.mnemonic {
+	case "":
+		return nil, nil
+	case "INCBIN":
+		return stmt.data, nil
+	case "DB", "DW":
+		limit := uint16(0xFF)
+		if stmt.mnemonic == "DW" {
+			limit = 0xFFFF
+		}
+		var buf []byte
+		for _, operand := range stmt.operands {
+			v, err := a.evalExpr(stmt, operand, limit)
+			if err != nil {
+				return nil, err
+			}
+			if stmt.mnemonic == "DW" {
+				buf = append(buf, byte(v>>8))
+			}
+			buf = append(buf, byte(v))
+		}
+		return buf, nil
+	}
+
+	op, foundMnemonic, ok := lookupInstruction(stmt.mnemonic, stmt.operands)
+	if !foundMnemonic {
+		return nil, stmt.errorf("unknown mnemonic: %s", stmt.mnemonic)
+	}
+	if !ok {
+		return nil, operandFormError(stmt)
+	}
+	var regs []uint8
+	value := uint16(0)
+	for i, kind := range InstructionOperandForms[op] {
+		operand := stmt.operands[i]
+		switch kind {
+		case OPERAND_REG:
+			regs = append(regs, operand.reg)
+		case OPERAND_ADDR, OPERAND_BYTE, OPERAND_NIBBLE:
+			v, err := a.evalExpr(stmt, operand, operandLimits[kind])
+			if err != nil {
+				return nil, err
+			}
+			if op == OP_1NNN || op == OP_2NNN || op == OP_BNNN {
+				if data := a.dataAt(v); data != nil { // legal, but mostly mistake
+					warning := stmt.operandErrorf(operand, "jump into data: %s (0x%03X) is in %s at %s:%d",
+						operand.expr, v, data.mnemonic, data.file, data.line)
+					warning.Severity = SEVERITY_WARNING
+					a.report(warning)
+				}
+			}
+			value = v
+		}
+	}
+	word := EncodeInstruction(op, regs, value)
+	return []byte{byte(word >> 8), byte(word)}, nil
+}
+
+// Encode write linked program. gaps between sections are filled with zero.
+// nothing is written if any error is reported
+func (a *Assembler) Encode(writer io.Writer) error {
+	rom := make([]byte, a.Size())
+	for _, section := range a.sections {
+		for _, stmt := range section.statements {
+			buf, err := a.encode(stmt)
+			if err != nil {
+				a.report(err)
+				continue
+			}
+			stmt.bytes = buf
+			copy(rom[int(stmt.addr)-Chip8ProgStartAddr:], buf)
+		}
+	}
+	a.diagnostics.Sort()
+	if err := a.checkDiagnostics(); err != nil {
+		return err
+	}
+	_, err := writer.Write(rom)
+	return err
+}
+
+func (a *Assembler) sortedSections() []*asmSection {
+	sections := make([]*asmSection, len(a.sections))
+	copy(sections, a.sections)
+	sort.SliceStable(sections, func(i, j int) bool { return sections[i].addr < sections[j].addr })
+	return sections
+}
+
+// SourceMap returns map from address of encoded statements to source line. must be called after Encode
+func (a *Assembler) SourceMap() *SourceMap {
+	sourceMap := &SourceMap{}
+	for _, section := range a.sortedSections() {
+		for _, stmt := range section.statements {
+			if stmt.size == 0 {
+				continue
+			}
+			sourceMap.Entries = append(sourceMap.Entries, SourceMapEntry{
+				Address: stmt.addr,
+				Size:    stmt.size,
+				File:    stmt.file,
+				Line:    stmt.line,
+				Source:  strings.TrimSpace(stmt.source),
+			})
+		}
+	}
+	return sourceMap
+}
+
+const listingBytesPerLine = 4
+
+// WriteListing write address, encoded bytes, line number and source line of each statement.
+// must be called after Encode
+func (a *Assembler) WriteListing(writer io.Writer) error {
+	file := ""
+	for _, section := range a.sortedSections() {
+		if len(section.statements) == 0 {
+			continue
+		}
+		_, _ = fmt.Fprintf(writer, "; section %s\n", section.name)
+		for _, stmt := range section.statements {
+			if stmt.file != file {
+				file = stmt.file
+				_, _ = fmt.Fprintf(writer, "; file %s\n", file)
+			}
+			for i := 0; i == 0 || i < len(stmt.bytes); i += listingBytesPerLine {
+				var hex []string
+				for j := i; j < i+listingBytesPerLine && j < len(stmt.bytes); j++ {
+					hex = append(hex, fmt.Sprintf("%02X", stmt.bytes[j]))
+				}
+				source := ""
+				if i == 0 {
+					source = fmt.Sprintf("%5d  %s", stmt.line, strings.TrimRight(stmt.source, " \t"))
+				}
+				line := fmt.Sprintf("0x%03X  %-11s  %s", int(stmt.addr)+i, strings.Join(hex, " "), source)
+				if _, err := fmt.Fprintln(writer, strings.TrimRight(line, " ")); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Assemble assemble source in disassembler syntax into CHIP-8 program
+func Assemble(reader io.Reader, writer io.Writer) error {
+	assembler := NewAssembler()
+	if err := assembler.Parse("<input>", reader, "."); err != nil {
+		return err
+	}
+	if err := assembler.Link(); err != nil {
+		return err
+	}
+	return assembler.Encode(writer)
+}
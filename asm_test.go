@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStripComment(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"    CLS ; clear", "    CLS "},
+		{`    INCBIN "a;b.bin" ; data`, `    INCBIN "a;b.bin" `},
+		{`    INCLUDE "a\";b.asm"`, `    INCLUDE "a\";b.asm"`},
+		{"    INCBIN `a;b.bin`;", "    INCBIN `a;b.bin`"},
+		{"; only comment", ""},
+	}
+	for _, tt := range tests {
+		if got := stripComment(tt.line); got != tt.want {
+			t.Errorf("stripComment(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestIncbinPathWithSemicolon(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a;b.bin"), []byte{0xF0, 0x90}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	assembler := NewAssembler()
+	if err := assembler.Parse("main.asm", strings.NewReader("    CLS\n    INCBIN \"a;b.bin\" ; sprite\n"), dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := assembler.Link(); err != nil {
+		t.Fatal(err)
+	}
+	var rom bytes.Buffer
+	if err := assembler.Encode(&rom); err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{0x00, 0xE0, 0xF0, 0x90}; !bytes.Equal(rom.Bytes(), want) {
+		t.Errorf("got % x, want % x", rom.Bytes(), want)
+	}
+}
+
+func TestInvalidConstantIsNotRecursive(t *testing.T) {
+	assembler := NewAssembler()
+	source := "X EQU Y+1\n    LD V1, X\n    LD V2, X\n"
+	if err := assembler.Parse("main.asm", strings.NewReader(source), "."); err == nil {
+		if err = assembler.Link(); err == nil {
+			_ = assembler.Encode(&bytes.Buffer{})
+		}
+	}
+	diagnostics := assembler.Diagnostics()
+	if !diagnostics.HasError() {
+		t.Fatal("no error is reported")
+	}
+	for _, diagnostic := range diagnostics {
+		if strings.Contains(diagnostic.Message, "recursive") {
+			t.Errorf("unexpected diagnostic: %s", diagnostic.Message)
+		}
+	}
+}
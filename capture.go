@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"github.com/alecthomas/kong"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"os/signal"
+	"strconv"
+	"time"
+)
+
+// Image returns 1 pixel per CHIP-8 pixel image colored by the palette
+func (s *Screen) Image(palette Palette) *image.Paletted {
+	colors := color.Palette{
+		color.RGBA{R: palette.Background.R, G: palette.Background.G, B: palette.Background.B, A: 0xFF},
+		color.RGBA{R: palette.Foreground.R, G: palette.Foreground.G, B: palette.Foreground.B, A: 0xFF},
+	}
+	img := image.NewPaletted(image.Rect(0, 0, ScreenWidth, ScreenHeight), colors)
+	for i, pixel := range s {
+		if pixel != 0 {
+			img.Pix[i] = 1
+		}
+	}
+	return img
+}
+
+func savePNG(path string, img image.Image) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err = png.Encode(file, img); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// writeScreenshot render the frame by post-processing pipeline, then save it as PNG
+func writeScreenshot(path string, pipeline *Pipeline, frame *Frame, scale int) error {
+	if err := savePNG(path, pipeline.Render(frame, scale)); err != nil {
+		return fmt.Errorf("screenshot error: %v", err)
+	}
+	fmt.Printf("screenshot: %s\n", path)
+	return nil
+}
+
+// screenshotPath returns file name of screenshot taken by hotkey
+func screenshotPath() string {
+	return fmt.Sprintf("octochip-%s.png", time.Now().Format("20060102-150405.000"))
+}
+
+// captureScale returns scale factor of captured image. fit has no window to fit, so initial window scale is used
+func captureScale(options DisplayOptions) int {
+	if options.Scale > 0 {
+		return options.Scale
+	}
+	return defaultWindowScale
+}
+
+// ScreenshotAt is frame number and output path given by --screenshot-at-frame N out.png
+type ScreenshotAt struct {
+	Frame int
+	Path  string
+}
+
+func (s *ScreenshotAt) Decode(ctx *kong.DecodeContext) error {
+	token, err := ctx.Scan.PopValue("frame")
+	if err != nil {
+		return err
+	}
+	if s.Frame, err = strconv.Atoi(token.String()); err != nil || s.Frame < 1 {
+		return fmt.Errorf("frame number must be positive integer: %s", token)
+	}
+	return ctx.Scan.PopValueInto("path", &s.Path)
+}
+
+// FrameCapture wraps Frontend and renders presented frames in software. frames are counted at 60 fps
+// independently of the frontend, so it works in the same way with headless frontend
+type FrameCapture struct {
+	Frontend
+	ticker     frameTicker
+	filter     *DisplayFilter
+	pipeline   *Pipeline
+	scale      int
+	frames     int           // number of frames elapsed on 60 fps timeline
+	screenshot *ScreenshotAt // nil after taken
+	quitAfter  bool          // stop VM when all captures are done
+	recorder   frameRecorder
+	audio      *wavRecorder
+	recording  bool
+	sound      func() bool // reports whether sound timer is active
+	interrupt  chan os.Signal
+}
+
+// RecordingToggler is implemented by frontends which toggle recording by hotkey
+type RecordingToggler interface {
+	OnToggleRecording(toggle func())
+}
+
+func NewFrameCapture(frontend Frontend, options DisplayOptions) *FrameCapture {
+	return &FrameCapture{
+		Frontend: frontend,
+		filter:   NewDisplayFilter(options),
+		pipeline: NewPipeline(options),
+		scale:    captureScale(options),
+	}
+}
+
+func (c *FrameCapture) Draw(screen *Screen) error {
+	if err := c.Frontend.Draw(screen); err != nil {
+		return err
+	}
+	return c.capture(screen, c.ticker.advance(time.Now()))
+}
+
+// capture process frames which became due. if VM is late, the screen is repeated for missed frames,
+// so captured files keep the 60 fps timeline they are stamped with
+func (c *FrameCapture) capture(screen *Screen, frames int) error {
+	for range frames {
+		c.frames++
+		frame := c.filter.Apply(screen)
+		if c.screenshot != nil && c.frames == c.screenshot.Frame {
+			if err := writeScreenshot(c.screenshot.Path, c.pipeline, frame, c.scale); err != nil {
+				return err
+			}
+			c.screenshot = nil
+		}
+		if c.recording {
+			if err := c.recorder.WriteFrame(c.pipeline.Render(frame, c.scale)); err != nil {
+				return fmt.Errorf("record error: %v", err)
+			}
+			if c.audio != nil {
+				if err := c.audio.WriteFrame(c.sound != nil && c.sound()); err != nil {
+					return fmt.Errorf("record error: %v", err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (c *FrameCapture) PollKey(keypad *Keypad) bool {
+	if c.quitAfter && c.screenshot == nil {
+		return false
+	}
+	select {
+	case <-c.interrupt: // stop VM to finish recording
+		fmt.Println("Quit: interrupted")
+		return false
+	default:
+	}
+	return c.Frontend.PollKey(keypad)
+}
+
+// startRecording open recorder of the video and optional WAV file. Ctrl-C stops VM instead of exit,
+// so files are completed by Teardown
+func (c *FrameCapture) startRecording(path string, audioPath string) error {
+	recorder, err := newFrameRecorder(path)
+	if err != nil {
+		return err
+	}
+	c.recorder, c.recording = recorder, true
+	if audioPath != "" {
+		if c.audio, err = newWavRecorder(audioPath); err != nil {
+			return err
+		}
+	}
+	if toggler, ok := c.Frontend.(RecordingToggler); ok {
+		toggler.OnToggleRecording(c.toggleRecording)
+	}
+	c.interrupt = make(chan os.Signal, 1)
+	signal.Notify(c.interrupt, os.Interrupt)
+	return nil
+}
+
+func (c *FrameCapture) toggleRecording() {
+	c.recording = !c.recording
+	if c.recording {
+		fmt.Println("recording: resumed")
+	} else {
+		fmt.Println("recording: paused")
+	}
+}
+
+// Teardown complete recorded files, then teardown frontend. it can be called more than once
+func (c *FrameCapture) Teardown() {
+	if c.recorder != nil {
+		if err := c.recorder.Close(); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "record error: %v\n", err)
+		}
+		c.recorder, c.recording = nil, false
+	}
+	if c.audio != nil {
+		if err := c.audio.Close(); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "record error: %v\n", err)
+		}
+		c.audio = nil
+	}
+	if c.interrupt != nil {
+		signal.Stop(c.interrupt)
+	}
+	c.Frontend.Teardown()
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/gif"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// recordFor simulate Draw calls of paced VM for the duration, with a stall in the middle.
+// it returns the number of frames elapsed on the 60 fps timeline
+func recordFor(t *testing.T, capture *FrameCapture, duration time.Duration) int {
+	t.Helper()
+	var screen Screen
+	start := time.Now()
+	wakeup := time.Second * time.Duration(defaultInstructionRate/60) / defaultInstructionRate
+	for elapsed := time.Duration(0); elapsed <= duration; elapsed += wakeup {
+		if elapsed > duration/2 && elapsed < duration/2+wakeup {
+			elapsed += 100 * time.Millisecond // VM was too slow
+		}
+		screen[int(elapsed/frameInterval)%len(screen)] ^= 1 // changes every frame
+		if err := capture.capture(&screen, capture.ticker.advance(start.Add(elapsed))); err != nil {
+			t.Fatalf("capture error: %v", err)
+		}
+	}
+	capture.Teardown()
+	return capture.frames
+}
+
+func TestRecordDuration(t *testing.T) {
+	for _, ext := range []string{".y4m", ".gif"} {
+		t.Run(ext, func(t *testing.T) {
+			dir := t.TempDir()
+			videoPath, audioPath := filepath.Join(dir, "rec"+ext), filepath.Join(dir, "rec.wav")
+			capture := NewFrameCapture(&HeadlessDevice{}, DisplayOptions{Scale: 1})
+			if err := capture.startRecording(videoPath, audioPath); err != nil {
+				t.Fatalf("record error: %v", err)
+			}
+			frames := recordFor(t, capture, 3*time.Second)
+			if frames < 179 || frames > 181 {
+				t.Errorf("elapsed %d frames in 3 seconds, want 180", frames)
+			}
+
+			video, err := os.ReadFile(videoPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var videoFrames int
+			switch ext {
+			case ".y4m":
+				videoFrames = bytes.Count(video, []byte("FRAME\n"))
+			case ".gif":
+				decoded, err := gif.DecodeAll(bytes.NewReader(video))
+				if err != nil {
+					t.Fatal(err)
+				}
+				delay := 0
+				for _, d := range decoded.Delay {
+					delay += d
+				}
+				videoFrames = (delay*recordFrameRate + 50) / 100 // delay is in 1/100 seconds
+			}
+			if videoFrames != frames {
+				t.Errorf("video has %d frames, want %d", videoFrames, frames)
+			}
+
+			audio, err := os.ReadFile(audioPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			samples := int(binary.LittleEndian.Uint32(audio[40:44])) / 2
+			if want := frames * wavSampleRate / recordFrameRate; samples != want {
+				t.Errorf("audio has %d samples, want %d", samples, want)
+			}
+		})
+	}
+}
+
+func TestScreenshotAtFrame(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shot.png")
+	capture := NewFrameCapture(&HeadlessDevice{}, DisplayOptions{Scale: 1})
+	capture.screenshot, capture.quitAfter = &ScreenshotAt{Frame: 120, Path: path}, true
+	var screen Screen
+	start := time.Now()
+	wakeup := time.Second * time.Duration(defaultInstructionRate/60) / defaultInstructionRate
+	elapsed := time.Duration(0)
+	for ; capture.screenshot != nil && elapsed < 3*time.Second; elapsed += wakeup {
+		if err := capture.capture(&screen, capture.ticker.advance(start.Add(elapsed))); err != nil {
+			t.Fatalf("capture error: %v", err)
+		}
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("screenshot is not written: %v", err)
+	}
+	if want := 2 * time.Second; elapsed < want-frameInterval || elapsed > want+frameInterval {
+		t.Errorf("frame 120 is taken at %v, want %v", elapsed, want)
+	}
+	if capture.PollKey(&Keypad{}) {
+		t.Errorf("headless run does not stop after screenshot")
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+type EdgeKind uint8
+
+const (
+	EDGE_FALL_THROUGH EdgeKind = iota // next instruction (or skip condition not met)
+	EDGE_SKIP                         // skip condition met
+	EDGE_JUMP                         // JP addr
+	EDGE_RETURN                       // return from CALL
+)
+
+var edgeKindNames = map[EdgeKind]string{
+	EDGE_FALL_THROUGH: "fall",
+	EDGE_SKIP:         "skip",
+	EDGE_JUMP:         "jump",
+	EDGE_RETURN:       "return",
+}
+
+type CFGEdge struct {
+	kind   EdgeKind
+	target uint16
+}
+
+// BasicBlock is a straight-line instruction sequence that only be entered from the first instruction
+type BasicBlock struct {
+	start        uint16
+	instructions []Instruction
+	succs        []CFGEdge
+}
+
+func (b *BasicBlock) last() Instruction {
+	return b.instructions[len(b.instructions)-1]
+}
+
+// Subroutine maintains basic blocks reachable from entry without following CALL
+type Subroutine struct {
+	entry  uint16
+	blocks []*BasicBlock // sorted by start address
+	calls  []uint16      // callee entries
+}
+
+type CFG struct {
+	buf         []byte
+	subroutines []*Subroutine // sorted by entry address
+	labelMap    map[uint16]string
+}
+
+func isSkipInstruction(op InstructionType) bool {
+	switch op {
+	case OP_3XNN, OP_4XNN, OP_5XY0, OP_9XY0, OP_EX9E, OP_EXA1:
+		return true
+	default:
+		return false
+	}
+}
+
+// successors of a single instruction. CALL is treated as returning to next instruction
+func instructionSuccs(ins Instruction) []CFGEdge {
+	addr := ins.Address()
+	switch ins.Type() {
+	case OP_00EE, OP_BNNN: // return or indirect jump
+		return nil
+	case OP_1NNN:
+		return []CFGEdge{{kind: EDGE_JUMP, target: ins.(AddrIns).target}}
+	case OP_2NNN:
+		return []CFGEdge{{kind: EDGE_RETURN, target: addr + 2}}
+	case OP_INVALID:
+		return nil
+	}
+	if isSkipInstruction(ins.Type()) {
+		return []CFGEdge{{kind: EDGE_FALL_THROUGH, target: addr + 2}, {kind: EDGE_SKIP, target: addr + 4}}
+	}
+	return []CFGEdge{{kind: EDGE_FALL_THROUGH, target: addr + 2}}
+}
+
+// collect instruction addresses reachable from entry within a subroutine
+func collectSubroutine(buf []byte, entry uint16) (reached map[uint16]Instruction, leaders map[uint16]bool) {
+	reached = make(map[uint16]Instruction)
+	leaders = map[uint16]bool{entry: true}
+	workList := []uint16{entry}
+	for len(workList) > 0 {
+		addr := workList[len(workList)-1]
+		workList = workList[:len(workList)-1]
+		if _, ok := reached[addr]; ok {
+			continue
+		}
+		ins, ok := decodeInstruction(buf, addr)
+		if !ok {
+			continue
+		}
+		reached[addr] = ins
+		succs := instructionSuccs(ins)
+		for _, succ := range succs {
+			if succ.kind != EDGE_FALL_THROUGH || len(succs) > 1 {
+				leaders[succ.target] = true
+			}
+			workList = append(workList, succ.target)
+		}
+	}
+	return
+}
+
+func buildSubroutine(buf []byte, entry uint16) *Subroutine {
+	reached, leaders := collectSubroutine(buf, entry)
+	sub := &Subroutine{entry: entry}
+	callees := make(map[uint16]bool)
+	var addrs []uint16
+	for addr := range reached {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+
+	// split into basic blocks
+	var block *BasicBlock
+	for _, addr := range addrs {
+		if block != nil {
+			succs := instructionSuccs(block.last())
+			if leaders[addr] || len(succs) != 1 || succs[0].kind != EDGE_FALL_THROUGH || succs[0].target != addr {
+				block = nil
+			}
+		}
+		if block == nil {
+			block = &BasicBlock{start: addr}
+			sub.blocks = append(sub.blocks, block)
+		}
+		ins := reached[addr]
+		block.instructions = append(block.instructions, ins)
+		if ins.Type() == OP_2NNN {
+			target := ins.(AddrIns).target
+			if !callees[target] {
+				callees[target] = true
+				sub.calls = append(sub.calls, target)
+			}
+		}
+	}
+
+	// resolve edges
+	for _, b := range sub.blocks {
+		for _, succ := range instructionSuccs(b.last()) {
+			if _, ok := reached[succ.target]; ok { // ignore edges to outside of program
+				b.succs = append(b.succs, succ)
+			}
+		}
+	}
+	return sub
+}
+
+// BuildCFG split program into basic blocks per subroutine. program entry is treated as subroutine
+func BuildCFG(buf []byte, annotation *Annotation) *CFG {
+	cfg := &CFG{buf: buf, labelMap: buildLabelMap(decodeInstructionSeq(buf, annotation), annotation)}
+	visited := make(map[uint16]bool)
+	workList := []uint16{Chip8ProgStartAddr}
+	for len(workList) > 0 {
+		entry := workList[0]
+		workList = workList[1:]
+		if visited[entry] {
+			continue
+		}
+		visited[entry] = true
+		sub := buildSubroutine(buf, entry)
+		if len(sub.blocks) == 0 { // outside of program
+			continue
+		}
+		cfg.subroutines = append(cfg.subroutines, sub)
+		workList = append(workList, sub.calls...)
+	}
+	sort.Slice(cfg.subroutines, func(i, j int) bool {
+		return cfg.subroutines[i].entry < cfg.subroutines[j].entry
+	})
+	return cfg
+}
+
+func (cfg *CFG) name(addr uint16) string {
+	if addr == Chip8ProgStartAddr {
+		return "start"
+	}
+	if label, ok := cfg.labelMap[addr]; ok {
+		return label
+	}
+	return fmt.Sprintf("@0x%03x", addr)
+}
+
+func escapeDot(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return strings.ReplaceAll(s, `"`, `\"`)
+}
+
+func (cfg *CFG) blockLabel(b *BasicBlock) string {
+	var buf bytes.Buffer
+	printer := InstructionPrinter{labelMap: cfg.labelMap, writer: &buf}
+	for _, ins := range b.instructions {
+		_, _ = fmt.Fprintf(&buf, "0x%03x", ins.Address())
+		_ = ins.Print(printer)
+	}
+	sb := strings.Builder{}
+	sb.WriteString(escapeDot(cfg.name(b.start)) + `:\l`)
+	for _, line := range strings.Split(strings.TrimRight(buf.String(), "\n"), "\n") {
+		sb.WriteString(escapeDot(line) + `\l`)
+	}
+	return sb.String()
+}
+
+// WriteDot write control-flow graph of each subroutine in DOT format
+func (cfg *CFG) WriteDot(writer io.Writer) error {
+	for _, sub := range cfg.subroutines {
+		_, _ = fmt.Fprintf(writer, "digraph \"%s\" {\n", escapeDot(cfg.name(sub.entry)))
+		_, _ = fmt.Fprintln(writer, "    node [shape=box, fontname=\"monospace\"];")
+		for _, b := range sub.blocks {
+			_, _ = fmt.Fprintf(writer, "    b_%03x [label=\"%s\"];\n", b.start, cfg.blockLabel(b))
+		}
+		for _, b := range sub.blocks {
+			for _, succ := range b.succs {
+				style := ""
+				if succ.kind == EDGE_SKIP {
+					style = ", style=dashed"
+				}
+				_, _ = fmt.Fprintf(writer, "    b_%03x -> b_%03x [label=\"%s\"%s];\n",
+					b.start, succ.target, edgeKindNames[succ.kind], style)
+			}
+		}
+		if _, err := fmt.Fprintln(writer, "}"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteCallGraphDot write whole-program call graph in DOT format
+func (cfg *CFG) WriteCallGraphDot(writer io.Writer) error {
+	_, _ = fmt.Fprintln(writer, "digraph \"callgraph\" {")
+	_, _ = fmt.Fprintln(writer, "    node [shape=box, fontname=\"monospace\"];")
+	for _, sub := range cfg.subroutines {
+		_, _ = fmt.Fprintf(writer, "    f_%03x [label=\"%s\"];\n", sub.entry, escapeDot(cfg.name(sub.entry)))
+	}
+	for _, sub := range cfg.subroutines {
+		for _, callee := range sub.calls {
+			_, _ = fmt.Fprintf(writer, "    f_%03x -> f_%03x;\n", sub.entry, callee)
+		}
+	}
+	_, err := fmt.Fprintln(writer, "}")
+	return err
+}
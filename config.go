@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+)
+
+/*
+config file (JSON). per-ROM settings are keyed by ROM file name and override global settings
+
+{
+  "keymap": "qwerty",
+  "keys": {"space": "5"},
+  "quit": "escape",
+  "gamepad": "octo",
+  "buttons": {"y": "0"},
+  "theme": "amber",
+  "foreground": "#FFFFFF",
+  "scale": "fit",
+  "fullscreen": false,
+  "fade": 0,
+  "upscale": "none",
+  "effects": ["scanlines"],
+  "keymaps": {
+    "mine": {"rows": [["1", "2", "3", "4"], ["q", "w", "e", "r"], ["a", "s", "d", "f"], ["z", "x", "c", "v"]]}
+  },
+  "themes": {
+    "night": {"foreground": "#8080FF", "background": "#000020"}
+  },
+  "gamepads": {
+    "tetris": {"dpleft": "5", "dpright": "6", "dpdown": "7", "a": "4"}
+  },
+  "roms": {
+    "tetris.ch8": {"keymap": "numpad", "gamepad": "tetris", "fade": 4, "deflicker": true}
+  }
+}
+*/
+
+// Settings can be specified globally and per ROM
+type Settings struct {
+	Keymap  string            `json:"keymap,omitempty"`  // preset or user-defined keymap name
+	Keys    map[string]string `json:"keys,omitempty"`    // key name to CHIP-8 key (hex digit). empty or "none" unbinds
+	Quit    string            `json:"quit,omitempty"`    // key name to quit
+	Gamepad string            `json:"gamepad,omitempty"` // preset or user-defined gamepad mapping name
+	Buttons map[string]string `json:"buttons,omitempty"` // gamepad button name to CHIP-8 key
+
+	Theme      string    `json:"theme,omitempty"`      // preset or user-defined theme name
+	Foreground string    `json:"foreground,omitempty"` // color of theme is overridden
+	Background string    `json:"background,omitempty"`
+	Scale      ScaleMode `json:"scale,omitempty"` // "fit" or integer scale factor
+	Fullscreen *bool     `json:"fullscreen,omitempty"`
+	Fade       *int      `json:"fade,omitempty"`      // frames of phosphor decay after pixel is turned off
+	Deflicker  *bool     `json:"deflicker,omitempty"` // pixel is lit if it is lit in either of the last two frames
+	Upscale    string    `json:"upscale,omitempty"`   // pixel-art upscaler (scale2x, epx, scale3x, scale4x, hq2x)
+	Effects    []string  `json:"effects,omitempty"`   // scanlines, grid, lcd. empty list of ROM disables global effects
+}
+
+type Config struct {
+	Settings
+	Keymaps  map[string]*KeymapConfig     `json:"keymaps,omitempty"`
+	Gamepads map[string]map[string]string `json:"gamepads,omitempty"` // button name to CHIP-8 key
+	Themes   map[string]*ThemeConfig      `json:"themes,omitempty"`
+	ROMs     map[string]*Settings         `json:"roms,omitempty"`
+}
+
+// merge override settings by non-empty fields of other
+func (s *Settings) merge(other *Settings) {
+	if other.Keymap != "" {
+		s.Keymap = other.Keymap
+	}
+	s.Keys = mergeBindings(s.Keys, other.Keys)
+	if other.Quit != "" {
+		s.Quit = other.Quit
+	}
+	if other.Gamepad != "" {
+		s.Gamepad = other.Gamepad
+	}
+	s.Buttons = mergeBindings(s.Buttons, other.Buttons)
+	if other.Theme != "" { // colors for other theme are not inherited
+		s.Theme, s.Foreground, s.Background = other.Theme, "", ""
+	}
+	if other.Foreground != "" {
+		s.Foreground = other.Foreground
+	}
+	if other.Background != "" {
+		s.Background = other.Background
+	}
+	if other.Scale != "" {
+		s.Scale = other.Scale
+	}
+	if other.Fullscreen != nil {
+		s.Fullscreen = other.Fullscreen
+	}
+	if other.Fade != nil {
+		s.Fade = other.Fade
+	}
+	if other.Deflicker != nil {
+		s.Deflicker = other.Deflicker
+	}
+	if other.Upscale != "" {
+		s.Upscale = other.Upscale
+	}
+	if other.Effects != nil {
+		s.Effects = other.Effects
+	}
+}
+
+func mergeBindings(bindings map[string]string, other map[string]string) map[string]string {
+	if len(other) == 0 {
+		return bindings
+	}
+	merged := maps.Clone(bindings)
+	if merged == nil {
+		merged = make(map[string]string)
+	}
+	maps.Copy(merged, other)
+	return merged
+}
+
+func LoadConfig(reader io.Reader) (*Config, error) {
+	config := &Config{}
+	decoder := json.NewDecoder(reader)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(config); err != nil {
+		return nil, fmt.Errorf("broken config: %v", err)
+	}
+	return config, nil
+}
+
+// DefaultConfigPath returns <user config dir>/octochip/config.json
+func DefaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "octochip", "config.json")
+}
+
+// loadConfig load config file. if path is empty, load default config file if exists
+func loadConfig(path string) (*Config, error) {
+	if path == "" {
+		path = DefaultConfigPath()
+		if _, err := os.Stat(path); path == "" || err != nil {
+			return &Config{}, nil
+		}
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	config, err := LoadConfig(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return config, nil
+}
+
+// ForROM returns global settings overridden by settings of the ROM
+func (c *Config) ForROM(romPath string) *Settings {
+	settings := &Settings{}
+	settings.merge(&c.Settings)
+	if romPath != "" {
+		if rom, ok := c.ROMs[filepath.Base(romPath)]; ok {
+			settings.merge(rom)
+		}
+	}
+	return settings
+}
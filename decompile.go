@@ -0,0 +1,299 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+type loopScope struct {
+	head uint16 // loop header address
+	back uint16 // address of backward jump
+}
+
+type decompiler struct {
+	cfg        *CFG
+	insMap     map[uint16]Instruction
+	addrs      []uint16 // sorted instruction addresses of current subroutine
+	gotoLabels map[uint16]bool
+	writer     strings.Builder
+}
+
+func regName(reg uint8) string {
+	return fmt.Sprintf("v%x", reg)
+}
+
+func (d *decompiler) funcName(addr uint16) string {
+	if label, ok := d.cfg.labelMap[addr]; ok {
+		return label
+	}
+	if addr == Chip8ProgStartAddr {
+		return "start"
+	}
+	return fmt.Sprintf("sub_%03x", addr)
+}
+
+func (d *decompiler) labelName(addr uint16) string {
+	if label, ok := d.cfg.labelMap[addr]; ok {
+		return label
+	}
+	return fmt.Sprintf("L_%03x", addr)
+}
+
+func (d *decompiler) addrExpr(addr uint16) string {
+	if label, ok := d.cfg.labelMap[addr]; ok {
+		return label
+	}
+	return fmt.Sprintf("0x%03x", addr)
+}
+
+// skipCondition returns condition that the skip instruction skips the next instruction
+func skipCondition(ins Instruction, negate bool) string {
+	op := map[bool]string{false: "==", true: "!="}
+	switch ins := ins.(type) {
+	case OneRegConstIns: // SE, SNE
+		return fmt.Sprintf("%s %s %d", regName(ins.reg), op[(ins.op == OP_4XNN) != negate], ins.num)
+	case TwoRegIns: // SE, SNE
+		return fmt.Sprintf("%s %s %s", regName(ins.reg1), op[(ins.op == OP_9XY0) != negate], regName(ins.reg2))
+	case OneRegIns: // SKP, SKNP
+		not := map[bool]string{false: "", true: "!"}
+		return fmt.Sprintf("%skey_pressed(%s)", not[(ins.op == OP_EXA1) != negate], regName(ins.reg))
+	}
+	return "?"
+}
+
+// statement renders non control-flow instruction
+func (d *decompiler) statement(ins Instruction) string {
+	switch ins := ins.(type) {
+	case ZeroIns:
+		if ins.op == OP_00E0 {
+			return "clear();"
+		}
+		return "return;"
+	case AddrIns:
+		switch ins.op {
+		case OP_0NNN:
+			return fmt.Sprintf("sys(0x%03x);", ins.target)
+		case OP_2NNN:
+			return fmt.Sprintf("%s();", d.funcName(ins.target))
+		case OP_ANNN:
+			return fmt.Sprintf("i = %s;", d.addrExpr(ins.target))
+		case OP_BNNN:
+			return fmt.Sprintf("goto *(%s + v0);", d.addrExpr(ins.target))
+		}
+	case OneRegConstIns:
+		x := regName(ins.reg)
+		switch ins.op {
+		case OP_6XNN:
+			return fmt.Sprintf("%s = %d;", x, ins.num)
+		case OP_7XNN:
+			return fmt.Sprintf("%s += %d;", x, ins.num)
+		case OP_CXNN:
+			return fmt.Sprintf("%s = random() & 0x%02x;", x, ins.num)
+		}
+	case TwoRegIns:
+		x, y := regName(ins.reg1), regName(ins.reg2)
+		switch ins.op {
+		case OP_8XY0:
+			return fmt.Sprintf("%s = %s;", x, y)
+		case OP_8XY1:
+			return fmt.Sprintf("%s |= %s;", x, y)
+		case OP_8XY2:
+			return fmt.Sprintf("%s &= %s;", x, y)
+		case OP_8XY3:
+			return fmt.Sprintf("%s ^= %s;", x, y)
+		case OP_8XY4:
+			return fmt.Sprintf("%s += %s; // vf = carry", x, y)
+		case OP_8XY5:
+			return fmt.Sprintf("%s -= %s; // vf = !borrow", x, y)
+		case OP_8XY6:
+			return fmt.Sprintf("%s >>= 1; // vf = shifted out bit", x)
+		case OP_8XY7:
+			return fmt.Sprintf("%s = %s - %s; // vf = !borrow", x, y, x)
+		case OP_8XYE:
+			return fmt.Sprintf("%s <<= 1; // vf = shifted out bit", x)
+		}
+	case TwoRegConstIns:
+		return fmt.Sprintf("vf = draw(%s, %s, %d);", regName(ins.reg1), regName(ins.reg2), ins.num)
+	case OneRegIns:
+		x := regName(ins.reg)
+		switch ins.op {
+		case OP_FX07:
+			return fmt.Sprintf("%s = delay;", x)
+		case OP_FX0A:
+			return fmt.Sprintf("%s = wait_key();", x)
+		case OP_FX15:
+			return fmt.Sprintf("delay = %s;", x)
+		case OP_FX18:
+			return fmt.Sprintf("sound = %s;", x)
+		case OP_FX1E:
+			return fmt.Sprintf("i += %s;", x)
+		case OP_FX29:
+			return fmt.Sprintf("i = font(%s);", x)
+		case OP_FX33:
+			return fmt.Sprintf("bcd(%s);", x)
+		case OP_FX55:
+			return fmt.Sprintf("save(v0..%s);", x)
+		case OP_FX65:
+			return fmt.Sprintf("load(v0..%s);", x)
+		}
+	case InvalidIns:
+		return fmt.Sprintf("/* invalid 0x%02x%02x */", ins.b1, ins.b2)
+	}
+	return "/* unknown */"
+}
+
+func (d *decompiler) line(depth int, format string, args ...any) {
+	d.writer.WriteString(strings.Repeat("    ", depth))
+	d.writer.WriteString(fmt.Sprintf(format, args...))
+	d.writer.WriteString("\n")
+}
+
+// jumpStatement renders jump to target as break, continue or goto
+func (d *decompiler) jumpStatement(target uint16, loop *loopScope) string {
+	if loop != nil {
+		if target == loop.head {
+			return "continue;"
+		}
+		if target == loop.back+2 {
+			return "break;"
+		}
+	}
+	d.gotoLabels[target] = true
+	return fmt.Sprintf("goto %s;", d.labelName(target))
+}
+
+func (d *decompiler) jumpTarget(addr uint16) (uint16, bool) {
+	if ins, ok := d.insMap[addr]; ok && ins.Type() == OP_1NNN {
+		return ins.(AddrIns).target, true
+	}
+	return 0, false
+}
+
+// loopBack find the last backward jump to head within [head, to)
+func (d *decompiler) loopBack(head uint16, to uint16) (uint16, bool) {
+	for i := len(d.addrs) - 1; i >= 0; i-- {
+		addr := d.addrs[i]
+		if addr < head || addr >= to {
+			continue
+		}
+		if target, ok := d.jumpTarget(addr); ok && target == head && addr != head {
+			return addr, true
+		}
+	}
+	return 0, false
+}
+
+func (d *decompiler) emitRange(from uint16, to uint16, depth int, loop *loopScope) {
+	index := sort.Search(len(d.addrs), func(i int) bool { return d.addrs[i] >= from })
+	for index < len(d.addrs) && d.addrs[index] < to {
+		addr := d.addrs[index]
+		ins := d.insMap[addr]
+		if d.gotoLabels[addr] {
+			d.line(depth-1, "%s:", d.labelName(addr))
+		}
+		next := addr + 2
+
+		if back, ok := d.loopBack(addr, to); ok && (loop == nil || loop.head != addr) {
+			d.line(depth, "while (true) {")
+			d.emitRange(addr, back, depth+1, &loopScope{head: addr, back: back})
+			d.line(depth, "}")
+			next = back + 2
+		} else if ins.Type() == OP_1NNN {
+			target := ins.(AddrIns).target
+			if target == addr {
+				d.line(depth, "while (true) {} // halt")
+			} else if loop == nil || addr != loop.back {
+				d.line(depth, "%s", d.jumpStatement(target, loop))
+			}
+		} else if isSkipInstruction(ins.Type()) {
+			next = d.emitSkip(ins, to, depth, loop)
+		} else {
+			d.line(depth, "%s", d.statement(ins))
+		}
+		index = sort.Search(len(d.addrs), func(i int) bool { return d.addrs[i] >= next })
+	}
+}
+
+// emitSkip renders skip instruction and following instructions as if statement. returns next address
+func (d *decompiler) emitSkip(ins Instruction, to uint16, depth int, loop *loopScope) uint16 {
+	addr := ins.Address()
+	next, ok := d.insMap[addr+2]
+	if !ok || addr+4 > to {
+		d.line(depth, "if (%s) %s", skipCondition(ins, false), d.jumpStatement(addr+4, loop))
+		return addr + 2
+	}
+
+	// skip + forward jump: if (cond) { then } [else { else }]
+	if target, ok := d.jumpTarget(addr + 2); ok && target > addr+4 && target <= to &&
+		(loop == nil || target <= loop.back) {
+		d.line(depth, "if (%s) {", skipCondition(ins, false))
+		end, ok := d.jumpTarget(target - 2)
+		if ok && target-2 >= addr+4 && end > target && end <= to && (loop == nil || end <= loop.back) {
+			d.emitRange(addr+4, target-2, depth+1, loop)
+			d.line(depth, "} else {")
+			d.emitRange(target, end, depth+1, loop)
+			d.line(depth, "}")
+			return end
+		}
+		d.emitRange(addr+4, target, depth+1, loop)
+		d.line(depth, "}")
+		return target
+	}
+
+	switch {
+	case next.Type() == OP_1NNN:
+		d.line(depth, "if (%s) %s", skipCondition(ins, true), d.jumpStatement(next.(AddrIns).target, loop))
+	case isSkipInstruction(next.Type()):
+		d.line(depth, "if (%s) %s", skipCondition(ins, false), d.jumpStatement(addr+4, loop))
+		return addr + 2
+	default:
+		d.line(depth, "if (%s) %s", skipCondition(ins, true), d.statement(next))
+	}
+	return addr + 4
+}
+
+func (d *decompiler) decompileSubroutine(sub *Subroutine) string {
+	d.insMap = make(map[uint16]Instruction)
+	d.addrs = nil
+	for _, b := range sub.blocks {
+		for _, ins := range b.instructions {
+			d.insMap[ins.Address()] = ins
+			d.addrs = append(d.addrs, ins.Address())
+		}
+	}
+	sort.Slice(d.addrs, func(i, j int) bool { return d.addrs[i] < d.addrs[j] })
+
+	// first pass collects goto labels, then render again with them
+	d.gotoLabels = make(map[uint16]bool)
+	for i := 0; i < 2; i++ {
+		d.writer.Reset()
+		d.line(0, "void %s() {", d.funcName(sub.entry))
+		d.emitRange(sub.entry, 0xFFFF, 1, nil)
+		if sub.blocks[0].start < sub.entry { // code before entry can only be reached by jump
+			d.emitRange(sub.blocks[0].start, sub.entry, 1, nil)
+		}
+		d.line(0, "}")
+	}
+	return d.writer.String()
+}
+
+// Decompile lift program into C-like pseudocode per subroutine
+func Decompile(reader io.Reader, writer io.Writer, annotation *Annotation) error {
+	buf, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	cfg := BuildCFG(buf, annotation)
+	d := decompiler{cfg: cfg}
+	for i, sub := range cfg.subroutines {
+		if i > 0 {
+			_, _ = fmt.Fprintln(writer)
+		}
+		if _, err := io.WriteString(writer, d.decompileSubroutine(sub)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+type Severity string
+
+const (
+	SEVERITY_ERROR   Severity = "error"
+	SEVERITY_WARNING Severity = "warning"
+	SEVERITY_INFO    Severity = "info"
+)
+
+// Diagnostic is a message which points to source location. Line and Column are 1-based (0 means unknown)
+type Diagnostic struct {
+	File     string   `json:"file,omitempty"`
+	Line     int      `json:"line,omitempty"`
+	Column   int      `json:"column,omitempty"`
+	Length   int      `json:"length,omitempty"`
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+	source   string   // source line for snippet
+}
+
+func (d *Diagnostic) Error() string {
+	sb := strings.Builder{}
+	if d.File != "" {
+		sb.WriteString(d.File + ":")
+		if d.Line > 0 {
+			sb.WriteString(fmt.Sprintf("%d:", d.Line))
+			if d.Column > 0 {
+				sb.WriteString(fmt.Sprintf("%d:", d.Column))
+			}
+		}
+		sb.WriteString(" ")
+	}
+	sb.WriteString(fmt.Sprintf("%s: %s", d.Severity, d.Message))
+	return sb.String()
+}
+
+// WriteSnippet write message with source line and caret
+func (d *Diagnostic) WriteSnippet(writer io.Writer) {
+	_, _ = fmt.Fprintln(writer, d.Error())
+	if d.source == "" || d.Column == 0 {
+		return
+	}
+	source := strings.ReplaceAll(d.source, "\t", " ")
+	_, _ = fmt.Fprintln(writer, source)
+	length := d.Length
+	if length < 1 {
+		length = 1
+	}
+	_, _ = fmt.Fprintf(writer, "%s^%s\n", strings.Repeat(" ", d.Column-1), strings.Repeat("~", length-1))
+}
+
+type Diagnostics []*Diagnostic
+
+func (d Diagnostics) Error() string {
+	messages := make([]string, 0, len(d))
+	for _, diagnostic := range d {
+		messages = append(messages, diagnostic.Error())
+	}
+	return strings.Join(messages, "\n")
+}
+
+func (d Diagnostics) HasError() bool {
+	for _, diagnostic := range d {
+		if diagnostic.Severity == SEVERITY_ERROR {
+			return true
+		}
+	}
+	return false
+}
+
+// Sort order diagnostics by location. files keep the order of first appearance
+func (d Diagnostics) Sort() {
+	fileOrder := map[string]int{}
+	for _, diagnostic := range d {
+		if _, ok := fileOrder[diagnostic.File]; !ok {
+			fileOrder[diagnostic.File] = len(fileOrder)
+		}
+	}
+	sort.SliceStable(d, func(i, j int) bool {
+		if d[i].File != d[j].File {
+			return fileOrder[d[i].File] < fileOrder[d[j].File]
+		}
+		if d[i].Line != d[j].Line {
+			return d[i].Line < d[j].Line
+		}
+		return d[i].Column < d[j].Column
+	})
+}
+
+func (d Diagnostics) WriteText(writer io.Writer) {
+	for _, diagnostic := range d {
+		diagnostic.WriteSnippet(writer)
+	}
+}
+
+func (d Diagnostics) WriteJSON(writer io.Writer) error {
+	if d == nil {
+		d = Diagnostics{}
+	}
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(d)
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+type DiffKind int
+
+const (
+	DIFF_SAME DiffKind = iota
+	DIFF_REMOVED
+	DIFF_INSERTED
+	DIFF_CHANGED
+)
+
+var diffMarks = map[DiffKind]string{
+	DIFF_SAME:     " ",
+	DIFF_REMOVED:  "-",
+	DIFF_INSERTED: "+",
+	DIFF_CHANGED:  "~",
+}
+
+// DiffLine is a pair of aligned instructions. a is nil if inserted, b is nil if removed
+type DiffLine struct {
+	kind DiffKind
+	a    Instruction
+	b    Instruction
+}
+
+type InstructionDiff struct {
+	lines     []DiffLine
+	labelMapA map[uint16]string
+	labelMapB map[uint16]string
+}
+
+// alignKey returns comparison key of instruction. address operands are ignored,
+// since they are shifted by inserted or removed instructions
+func alignKey(ins Instruction) string {
+	if addrIns, ok := ins.(AddrIns); ok {
+		return InstructionTypeNames[addrIns.op] + " @"
+	}
+	sb := strings.Builder{}
+	_ = ins.Print(InstructionPrinter{writer: &sb})
+	return sb.String()
+}
+
+// lcs returns longest common subsequence of keys as index pairs
+func lcs(keysA []string, keysB []string) [][2]int {
+	n, m := len(keysA), len(keysB)
+	table := make([][]uint16, n+1)
+	for i := range table {
+		table[i] = make([]uint16, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if keysA[i] == keysB[j] {
+				table[i][j] = table[i+1][j+1] + 1
+			} else if table[i+1][j] >= table[i][j+1] {
+				table[i][j] = table[i+1][j]
+			} else {
+				table[i][j] = table[i][j+1]
+			}
+		}
+	}
+	var pairs [][2]int
+	for i, j := 0, 0; i < n && j < m; {
+		switch {
+		case keysA[i] == keysB[j]:
+			pairs = append(pairs, [2]int{i, j})
+			i++
+			j++
+		case table[i+1][j] >= table[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	return pairs
+}
+
+// DiffInstructions align instructions of two programs and compute inserted, removed and changed instructions.
+// jump targets are compared through the alignment, so instructions only shifted are treated as same
+func DiffInstructions(bufA []byte, bufB []byte, annotationA *Annotation, annotationB *Annotation) *InstructionDiff {
+	seqA := decodeInstructionSeq(bufA, annotationA)
+	seqB := decodeInstructionSeq(bufB, annotationB)
+	keysA := make([]string, len(seqA))
+	for i, ins := range seqA {
+		keysA[i] = alignKey(ins)
+	}
+	keysB := make([]string, len(seqB))
+	for i, ins := range seqB {
+		keysB[i] = alignKey(ins)
+	}
+	pairs := lcs(keysA, keysB)
+	diff := &InstructionDiff{labelMapA: buildLabelMap(seqA, annotationA), labelMapB: make(map[uint16]string)}
+
+	// unaligned instructions of the same type are treated as changed, others are removed or inserted
+	gap := func(fromA, toA, fromB, toB int) {
+		opsA := make([]string, toA-fromA)
+		for i := range opsA {
+			opsA[i] = InstructionTypeNames[seqA[fromA+i].Type()]
+		}
+		opsB := make([]string, toB-fromB)
+		for i := range opsB {
+			opsB[i] = InstructionTypeNames[seqB[fromB+i].Type()]
+		}
+		i, j := fromA, fromB
+		for _, pair := range append(lcs(opsA, opsB), [2]int{toA - fromA, toB - fromB}) {
+			for ; i < fromA+pair[0]; i++ {
+				diff.lines = append(diff.lines, DiffLine{kind: DIFF_REMOVED, a: seqA[i]})
+			}
+			for ; j < fromB+pair[1]; j++ {
+				diff.lines = append(diff.lines, DiffLine{kind: DIFF_INSERTED, b: seqB[j]})
+			}
+			if i < toA && j < toB {
+				diff.lines = append(diff.lines, DiffLine{kind: DIFF_CHANGED, a: seqA[i], b: seqB[j]})
+				i++
+				j++
+			}
+		}
+	}
+	i, j := 0, 0
+	for _, pair := range pairs {
+		gap(i, pair[0], j, pair[1])
+		diff.lines = append(diff.lines, DiffLine{kind: DIFF_SAME, a: seqA[pair[0]], b: seqB[pair[1]]})
+		i, j = pair[0]+1, pair[1]+1
+	}
+	gap(i, len(seqA), j, len(seqB))
+
+	addrMap := make(map[uint16]uint16) // address of A to corresponding address of B
+	for _, line := range diff.lines {
+		if line.a != nil && line.b != nil {
+			addrMap[line.a.Address()] = line.b.Address()
+		}
+	}
+	for i, line := range diff.lines {
+		if addrA, ok := line.a.(AddrIns); ok && line.kind == DIFF_SAME {
+			target, ok := addrMap[addrA.target]
+			if !ok { // target is not aligned instruction (ex. data)
+				target = addrA.target
+			}
+			if target != line.b.(AddrIns).target {
+				diff.lines[i].kind = DIFF_CHANGED
+			}
+		}
+	}
+	for addr, name := range diff.labelMapA { // share label names of corresponding addresses
+		if addrB, ok := addrMap[addr]; ok {
+			diff.labelMapB[addrB] = name
+		}
+	}
+	if annotationB != nil {
+		for addr, name := range annotationB.labels {
+			diff.labelMapB[addr] = name
+		}
+	}
+	return diff
+}
+
+// Count returns number of lines of the kind
+func (d *InstructionDiff) Count(kind DiffKind) int {
+	count := 0
+	for _, line := range d.lines {
+		if line.kind == kind {
+			count++
+		}
+	}
+	return count
+}
+
+func (d *InstructionDiff) text(ins Instruction, labelMap map[uint16]string) string {
+	sb := strings.Builder{}
+	_ = ins.Print(InstructionPrinter{writer: &sb, labelMap: labelMap})
+	return strings.TrimSpace(sb.String())
+}
+
+func (d *InstructionDiff) writeLine(writer io.Writer, line DiffLine) {
+	addrA, addrB := "     ", "     "
+	if line.a != nil {
+		addrA = fmt.Sprintf("0x%03X", line.a.Address())
+	}
+	if line.b != nil {
+		addrB = fmt.Sprintf("0x%03X", line.b.Address())
+	}
+	var text string
+	switch line.kind {
+	case DIFF_INSERTED:
+		text = d.text(line.b, d.labelMapB)
+	case DIFF_CHANGED:
+		text = fmt.Sprintf("%-20s => %s", d.text(line.a, d.labelMapA), d.text(line.b, d.labelMapB))
+	default:
+		text = d.text(line.a, d.labelMapA)
+	}
+	_, _ = fmt.Fprintf(writer, "%s %s %s  %s\n", diffMarks[line.kind], addrA, addrB, text)
+}
+
+// Write write differences as hunks with context lines, like unified diff
+func (d *InstructionDiff) Write(writer io.Writer, nameA string, nameB string, context int) error {
+	_, _ = fmt.Fprintf(writer, "--- %s\n+++ %s\n", nameA, nameB)
+	show := make([]bool, len(d.lines))
+	for i, line := range d.lines {
+		if line.kind == DIFF_SAME {
+			continue
+		}
+		for j := i - context; j <= i+context; j++ {
+			if j >= 0 && j < len(show) {
+				show[j] = true
+			}
+		}
+	}
+	for i := 0; i < len(d.lines); i++ {
+		if !show[i] {
+			continue
+		}
+		if i == 0 || !show[i-1] {
+			_, _ = fmt.Fprintf(writer, "@@ %s @@\n", d.hunkHeader(i))
+		}
+		d.writeLine(writer, d.lines[i])
+	}
+	_, err := fmt.Fprintf(writer, "%d changed, %d inserted, %d removed\n",
+		d.Count(DIFF_CHANGED), d.Count(DIFF_INSERTED), d.Count(DIFF_REMOVED))
+	return err
+}
+
+// hunkHeader returns the first addresses of both programs from the line
+func (d *InstructionDiff) hunkHeader(index int) string {
+	addrA, addrB := "", ""
+	for _, line := range d.lines[index:] {
+		if addrA == "" && line.a != nil {
+			addrA = fmt.Sprintf("-0x%03X", line.a.Address())
+		}
+		if addrB == "" && line.b != nil {
+			addrB = fmt.Sprintf("+0x%03X", line.b.Address())
+		}
+	}
+	return strings.TrimSpace(addrA + " " + addrB)
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"fmt"
 	"io"
+	"strconv"
+	"strings"
 )
 
 var instructionBuilders = map[InstructionType]func(uint16, InstructionType, byte, byte, byte) Instruction{
@@ -43,30 +45,54 @@ var instructionBuilders = map[InstructionType]func(uint16, InstructionType, byte
 	OP_FX65: NewOneRegIns,
 }
 
-func Disassemble(reader io.Reader, writer io.Writer) error {
-	buf, err := io.ReadAll(reader)
-	if err != nil {
-		return err
+func decodeInstruction(buf []byte, addr uint16) (Instruction, bool) {
+	i := int(addr) - Chip8ProgStartAddr
+	if i < 0 || i+1 >= len(buf) {
+		return nil, false
+	}
+	op, r1, r2, r3 := DecodeInstruction(buf[i], buf[i+1])
+	word := uint16(buf[i])<<8 | uint16(buf[i+1])
+	if op == OP_INVALID || encodeDecoded(op, r1, r2, r3) != word { // not canonical form (ex. 5XY1)
+		return InvalidIns{addr: addr, b1: buf[i], b2: buf[i+1]}, true
 	}
+	return instructionBuilders[op](addr, op, r1, r2, r3), true
+}
 
-	// generate instruction sequence
+// encodeDecoded re-encode decoded instruction. only operands used by the instruction are kept
+func encodeDecoded(op InstructionType, r1 byte, r2 byte, r3 byte) uint16 {
+	var regs []uint8
+	value := uint16(0)
+	for _, kind := range InstructionOperandForms[op] {
+		switch kind {
+		case OPERAND_REG:
+			regs = append(regs, []uint8{r1, r2}[len(regs)])
+		case OPERAND_ADDR:
+			value = uint16(r1)<<8 | uint16(r2)<<4 | uint16(r3)
+		case OPERAND_BYTE:
+			value = uint16(r2)<<4 | uint16(r3)
+		case OPERAND_NIBBLE:
+			value = uint16(r3)
+		}
+	}
+	return EncodeInstruction(op, regs, value)
+}
+
+// decodeInstructionSeq decode program linearly. data regions of annotation are skipped
+func decodeInstructionSeq(buf []byte, annotation *Annotation) []Instruction {
 	var instructionSeq []Instruction
-	for i := 0; i < len(buf); i++ {
-		if i+1 < len(buf) {
-			op, r1, r2, r3 := DecodeInstruction(buf[i], buf[i+1])
-			addr := uint16(Chip8ProgStartAddr + i)
-			var ins Instruction
-			if op != OP_INVALID {
-				ins = instructionBuilders[op](addr, op, r1, r2, r3)
-			} else {
-				ins = InvalidIns{addr: addr, b1: buf[i], b2: buf[i+1]}
-			}
-			instructionSeq = append(instructionSeq, ins)
-			i++
+	for i := 0; i+1 < len(buf); i += 2 {
+		addr := uint16(Chip8ProgStartAddr + i)
+		if annotation.isData(addr) {
+			i-- // next code may start at odd address
+			continue
 		}
+		ins, _ := decodeInstruction(buf, addr)
+		instructionSeq = append(instructionSeq, ins)
 	}
+	return instructionSeq
+}
 
-	// resolve jump target
+func buildLabelMap(instructionSeq []Instruction, annotation *Annotation) map[uint16]string {
 	labelMap := make(map[uint16]string)
 	labelIdCount := 0
 	for _, ins := range instructionSeq {
@@ -84,17 +110,243 @@ func Disassemble(reader io.Reader, writer io.Writer) error {
 			labelIdCount++
 		}
 	}
+	if annotation != nil {
+		for addr, name := range annotation.labels {
+			labelMap[addr] = name
+		}
+	}
+	return labelMap
+}
+
+type DisasmOption struct {
+	Annotation *Annotation
+	ShowAddr   bool   // print address and raw opcode word
+	Start      string // address or label name of the first printed instruction
+	End        string // address or label name of the last printed instruction
+	Label      string // only print the region of the label
+	Original   []byte // original ROM. if not nil, bytes differ from it are annotated
+}
+
+// ProgramFromMemoryImage extract program area from RAM image. trailing zero bytes are trimmed but keep original ROM size
+func ProgramFromMemoryImage(image []byte, original []byte) ([]byte, error) {
+	if len(image) != Chip8RAMSize {
+		return nil, fmt.Errorf("memory image must be %d bytes, but %d bytes", Chip8RAMSize, len(image))
+	}
+	end := len(image)
+	for end > Chip8ProgStartAddr+len(original) && image[end-1] == 0 {
+		end--
+	}
+	if (end-Chip8ProgStartAddr)%2 != 0 && end < len(image) {
+		end++ // keep last instruction word
+	}
+	return image[Chip8ProgStartAddr:end], nil
+}
+
+func (o *DisasmOption) modifiedComment(buf []byte, addr uint16, size int) (string, bool) {
+	if o.Original == nil {
+		return "", false
+	}
+	i := int(addr - Chip8ProgStartAddr)
+	modified := false
+	orig := ""
+	for j := i; j < i+size; j++ {
+		if j >= len(o.Original) {
+			modified = modified || buf[j] != 0
+			orig += "--"
+		} else {
+			modified = modified || buf[j] != o.Original[j]
+			orig += fmt.Sprintf("%02X", o.Original[j])
+		}
+	}
+	return "modified (rom: " + orig + ")", modified
+}
+
+func resolveAddress(s string, labelMap map[uint16]string) (uint16, error) {
+	if s == "start" {
+		return Chip8ProgStartAddr, nil
+	}
+	for addr, label := range labelMap {
+		if label == s {
+			return addr, nil
+		}
+	}
+	v, err := strconv.ParseUint(s, 0, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid address or undefined label: %s", s)
+	}
+	if v >= Chip8RAMSize {
+		return 0, fmt.Errorf("address out of range: %s", s)
+	}
+	return uint16(v), nil
+}
+
+// resolve printed address range [start, end]
+func (o *DisasmOption) resolveRange(buf []byte, labelMap map[uint16]string) (start uint16, end uint16, err error) {
+	start = Chip8ProgStartAddr
+	end = Chip8RAMSize - 1
+	if o.Label != "" {
+		if start, err = resolveAddress(o.Label, labelMap); err != nil {
+			return
+		}
+		isSub := strings.HasPrefix(labelMap[start], "subroutine")
+		if o.Annotation != nil && o.Annotation.subroutines[start] {
+			isSub = true
+		}
+		end = labelRegionEnd(buf, start, isSub, labelMap)
+	}
+	if o.Start != "" {
+		if start, err = resolveAddress(o.Start, labelMap); err != nil {
+			return
+		}
+	}
+	if o.End != "" {
+		if end, err = resolveAddress(o.End, labelMap); err != nil {
+			return
+		}
+	}
+	if start > end {
+		err = fmt.Errorf("empty address range: 0x%03X-0x%03X", start, end)
+	}
+	return
+}
+
+// labelRegionEnd returns last instruction address of subroutine if start is subroutine entry.
+// otherwise, returns address before the next label
+func labelRegionEnd(buf []byte, start uint16, isSub bool, labelMap map[uint16]string) uint16 {
+	end := uint16(Chip8RAMSize - 1)
+	if start == Chip8ProgStartAddr || isSub {
+		sub := buildSubroutine(buf, start)
+		if len(sub.blocks) > 0 {
+			return sub.blocks[len(sub.blocks)-1].last().Address()
+		}
+	}
+	for addr := range labelMap {
+		if addr > start && addr-1 < end {
+			end = addr - 1
+		}
+	}
+	return end
+}
+
+// dataPrinter prints consecutive data bytes as DB directive
+type dataPrinter struct {
+	writer   io.Writer
+	showAddr bool
+	addr     uint16
+	bytes    []byte
+}
+
+const dataBytesPerLine = 8
+
+func (d *dataPrinter) add(addr uint16, b byte) {
+	if len(d.bytes) == 0 {
+		d.addr = addr
+	}
+	d.bytes = append(d.bytes, b)
+	if len(d.bytes) == dataBytesPerLine {
+		d.flush()
+	}
+}
+
+func (d *dataPrinter) flush() {
+	if len(d.bytes) == 0 {
+		return
+	}
+	if d.showAddr {
+		_, _ = fmt.Fprintf(d.writer, "0x%03X      ", d.addr)
+	}
+	_, _ = fmt.Fprintf(d.writer, "    %-4s  ", "DB")
+	for i, b := range d.bytes {
+		if i > 0 {
+			_, _ = fmt.Fprint(d.writer, ", ")
+		}
+		_, _ = fmt.Fprintf(d.writer, "0x%02x", b)
+	}
+	_, _ = fmt.Fprintln(d.writer)
+	d.bytes = d.bytes[:0]
+}
+
+func Disassemble(reader io.Reader, writer io.Writer, option DisasmOption) error {
+	buf, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	annotation := option.Annotation
+
+	// generate instruction sequence
+	instructionSeq := decodeInstructionSeq(buf, annotation)
+
+	// resolve jump target
+	labelMap := buildLabelMap(instructionSeq, annotation)
+
+	// labels must be placed at start of instruction or data byte
+	insideIns := make(map[uint16]bool)
+	for _, ins := range instructionSeq {
+		insideIns[ins.Address()+1] = true
+	}
+	for addr := range labelMap {
+		if addr < Chip8ProgStartAddr || int(addr) >= Chip8ProgStartAddr+len(buf) || insideIns[addr] {
+			delete(labelMap, addr)
+		}
+	}
+
+	start, end, err := option.resolveRange(buf, labelMap)
+	if err != nil {
+		return err
+	}
 
 	// print sequence
 	printer := InstructionPrinter{labelMap: labelMap, writer: writer}
-	_, _ = fmt.Fprintln(printer.writer, "start:")
-	for _, ins := range instructionSeq {
-		if label, ok := labelMap[ins.Address()]; ok {
+	data := dataPrinter{writer: writer, showAddr: option.ShowAddr}
+	printHeader := func(addr uint16) {
+		label, hasLabel := labelMap[addr]
+		var comments []string
+		if annotation != nil {
+			comments = annotation.comments[addr]
+		}
+		if hasLabel || len(comments) > 0 {
+			data.flush()
+		}
+		if hasLabel {
 			_, _ = fmt.Fprintf(printer.writer, "%s:\n", label)
 		}
-		if err := ins.Print(printer); err != nil {
-			return err
+		for _, comment := range comments {
+			_, _ = fmt.Fprintf(printer.writer, "    ; %s\n", comment)
+		}
+	}
+	if start <= Chip8ProgStartAddr && labelMap[Chip8ProgStartAddr] == "" {
+		_, _ = fmt.Fprintln(printer.writer, "start:")
+	}
+	index := 0
+	for i := 0; i < len(buf); i++ {
+		addr := uint16(Chip8ProgStartAddr + i)
+		if index < len(instructionSeq) && instructionSeq[index].Address() == addr {
+			ins := instructionSeq[index]
+			index++
+			i++
+			if addr < start || addr > end {
+				continue
+			}
+			printHeader(addr)
+			data.flush()
+			if comment, ok := option.modifiedComment(buf, addr, 2); ok {
+				_, _ = fmt.Fprintf(printer.writer, "    ; %s\n", comment)
+			}
+			if option.ShowAddr {
+				_, _ = fmt.Fprintf(printer.writer, "0x%03X  %02X%02X", addr, buf[i-1], buf[i])
+			}
+			if err := ins.Print(printer); err != nil {
+				return err
+			}
+		} else if addr >= start && addr <= end { // data region or trailing byte
+			printHeader(addr)
+			if comment, ok := option.modifiedComment(buf, addr, 1); ok {
+				data.flush()
+				_, _ = fmt.Fprintf(printer.writer, "    ; %s\n", comment)
+			}
+			data.add(addr, buf[i])
 		}
 	}
+	data.flush()
 	return nil
 }
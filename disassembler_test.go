@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// roundTrip disassemble the ROM, then assemble the output
+func roundTrip(t *testing.T, rom []byte) []byte {
+	t.Helper()
+	var source bytes.Buffer
+	if err := Disassemble(bytes.NewReader(rom), &source, DisasmOption{}); err != nil {
+		t.Fatalf("disassemble error: %v", err)
+	}
+	var output bytes.Buffer
+	if err := Assemble(bytes.NewReader(source.Bytes()), &output); err != nil {
+		t.Fatalf("assemble error: %v\n%s", err, source.String())
+	}
+	return output.Bytes()
+}
+
+func TestDisassembleRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		rom  []byte
+	}{
+		{"program", []byte{
+			0x00, 0xE0, // CLS
+			0x6A, 0x05, // LD VA, 0x05
+			0xA2, 0x0C, // LD I, data
+			0xDA, 0xB5, // DRW VA, VB, 5
+			0x22, 0x0E, // CALL sub
+			0x12, 0x0A, // JP self
+			0xF0, 0x90, // data
+			0x00, 0xEE, // RET
+		}},
+		{"not canonical 5XY1", []byte{0x51, 0x21}},
+		{"not canonical 8XY8", []byte{0x81, 0x28}},
+		{"not canonical 9XY1", []byte{0x91, 0x21}},
+		{"not canonical EX00", []byte{0xE1, 0x00}},
+		{"not canonical FX00", []byte{0xF1, 0x00}},
+		{"odd size", []byte{0x60, 0x01, 0xFF}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := roundTrip(t, tt.rom); !bytes.Equal(got, tt.rom) {
+				t.Errorf("got % x, want % x", got, tt.rom)
+			}
+		})
+	}
+}
+
+func TestDisassembleRoundTripAllWords(t *testing.T) {
+	for word := 0; word <= 0xFFFF; word++ {
+		rom := []byte{byte(word >> 8), byte(word)}
+		if got := roundTrip(t, rom); !bytes.Equal(got, rom) {
+			t.Fatalf("got % x, want % x", got, rom)
+		}
+	}
+}
+
+func TestDecodeNotCanonical(t *testing.T) {
+	for _, word := range []uint16{0x5121, 0x812F, 0x9121, 0xE1FF, 0xF1FF} {
+		ins, ok := decodeInstruction([]byte{byte(word >> 8), byte(word)}, Chip8ProgStartAddr)
+		if !ok {
+			t.Fatalf("%04X: not decoded", word)
+		}
+		if _, invalid := ins.(InvalidIns); !invalid {
+			t.Errorf("%04X: got %T, want InvalidIns", word, ins)
+		}
+	}
+}
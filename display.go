@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type Color struct {
+	R, G, B uint8
+}
+
+// ParseColor parse #RRGGBB
+func ParseColor(s string) (Color, error) {
+	hex := strings.TrimPrefix(s, "#")
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || len(hex) != 6 {
+		return Color{}, fmt.Errorf("color must be #RRGGBB: %s", s)
+	}
+	return Color{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v)}, nil
+}
+
+func (c Color) String() string {
+	return fmt.Sprintf("#%02X%02X%02X", c.R, c.G, c.B)
+}
+
+type Palette struct {
+	Foreground Color
+	Background Color
+}
+
+// Shade returns color blended from background to foreground by the intensity
+func (p Palette) Shade(intensity uint8) Color {
+	blend := func(bg uint8, fg uint8) uint8 {
+		return uint8((int(bg)*(maxIntensity-int(intensity)) + int(fg)*int(intensity)) / maxIntensity)
+	}
+	return Color{
+		R: blend(p.Background.R, p.Foreground.R),
+		G: blend(p.Background.G, p.Foreground.G),
+		B: blend(p.Background.B, p.Foreground.B),
+	}
+}
+
+const defaultTheme = "mono"
+
+var paletteThemes = map[string]Palette{
+	"mono":    {Foreground: Color{0xFF, 0xFF, 0xFF}, Background: Color{0x00, 0x00, 0x00}},
+	"inverse": {Foreground: Color{0x00, 0x00, 0x00}, Background: Color{0xFF, 0xFF, 0xFF}},
+	"amber":   {Foreground: Color{0xFF, 0xB0, 0x00}, Background: Color{0x1A, 0x10, 0x00}},
+	"green":   {Foreground: Color{0x33, 0xFF, 0x66}, Background: Color{0x00, 0x1A, 0x08}},
+	"lcd":     {Foreground: Color{0x0F, 0x38, 0x0F}, Background: Color{0x9B, 0xBC, 0x0F}},
+	"octo":    {Foreground: Color{0xFF, 0xCC, 0x00}, Background: Color{0x99, 0x66, 0x00}},
+}
+
+// ThemeConfig defines palette by colors (#RRGGBB)
+type ThemeConfig struct {
+	Foreground string `json:"foreground"`
+	Background string `json:"background"`
+}
+
+// ScaleMode is "fit" or integer scale factor. it can be written as number in config
+type ScaleMode string
+
+const SCALE_FIT ScaleMode = "fit"
+
+func (s *ScaleMode) UnmarshalJSON(data []byte) error {
+	var n int
+	if err := json.Unmarshal(data, &n); err == nil {
+		*s = ScaleMode(strconv.Itoa(n))
+		return nil
+	}
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return fmt.Errorf("scale must be \"fit\" or integer: %s", data)
+	}
+	*s = ScaleMode(str)
+	return nil
+}
+
+// Factor returns integer scale factor, or 0 for fit
+func (s ScaleMode) Factor() (int, error) {
+	if s == "" || s == SCALE_FIT {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(string(s))
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("scale must be \"fit\" or positive integer: %s", s)
+	}
+	return n, nil
+}
+
+const defaultWindowScale = 10 // initial window size for fit mode
+
+type DisplayOptions struct {
+	Palette    Palette
+	Scale      int // integer scale factor. 0 fits to window keeping aspect ratio
+	Fullscreen bool
+	Fade       int  // frames of phosphor decay
+	Deflicker  bool // OR the last two frames
+	Upscale    string
+	Effects    []string
+}
+
+// NewDisplayOptions resolve theme, colors and scale of settings
+func (c *Config) NewDisplayOptions(settings *Settings) (DisplayOptions, error) {
+	name := settings.Theme
+	if name == "" {
+		name = defaultTheme
+	}
+	var options DisplayOptions
+	if theme, ok := c.Themes[name]; ok {
+		var err error
+		if options.Palette.Foreground, err = ParseColor(theme.Foreground); err != nil {
+			return options, fmt.Errorf("theme %s: %v", name, err)
+		}
+		if options.Palette.Background, err = ParseColor(theme.Background); err != nil {
+			return options, fmt.Errorf("theme %s: %v", name, err)
+		}
+	} else if options.Palette, ok = paletteThemes[name]; !ok {
+		return options, fmt.Errorf("unknown theme: %s", name)
+	}
+	var err error
+	if settings.Foreground != "" {
+		if options.Palette.Foreground, err = ParseColor(settings.Foreground); err != nil {
+			return options, err
+		}
+	}
+	if settings.Background != "" {
+		if options.Palette.Background, err = ParseColor(settings.Background); err != nil {
+			return options, err
+		}
+	}
+	if options.Scale, err = settings.Scale.Factor(); err != nil {
+		return options, err
+	}
+	options.Fullscreen = settings.Fullscreen != nil && *settings.Fullscreen
+	if settings.Fade != nil {
+		if *settings.Fade < 0 {
+			return options, fmt.Errorf("fade must not be negative: %d", *settings.Fade)
+		}
+		options.Fade = *settings.Fade
+	}
+	options.Deflicker = settings.Deflicker != nil && *settings.Deflicker
+	options.Upscale, options.Effects = settings.Upscale, settings.Effects
+	if options.Upscale == "" {
+		options.Upscale = defaultUpscaler
+	}
+	if err := validatePostProcess(options.Upscale, options.Effects); err != nil {
+		return options, err
+	}
+	return options, nil
+}
+
+// letterbox returns rectangle to draw screen image centered in output of the size.
+// integer scale is reduced if output is too small, and 0 scale fits to output keeping aspect ratio
+func letterbox(width int, height int, scale int) (x int, y int, w int, h int) {
+	if scale > 0 {
+		scale = max(1, min(scale, width/ScreenWidth, height/ScreenHeight))
+		w, h = ScreenWidth*scale, ScreenHeight*scale
+	} else {
+		w, h = width, width*ScreenHeight/ScreenWidth
+		if h > height {
+			w, h = height*ScreenWidth/ScreenHeight, height
+		}
+	}
+	return (width - w) / 2, (height - h) / 2, w, h
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"time"
+)
+
+// Frame is intensity of each pixel presented to the display, 0 (background) to 255 (foreground)
+type Frame [ScreenWidth * ScreenHeight]uint8
+
+const maxIntensity = 255
+
+// DisplayFilter reduces flicker of XOR drawing. it is applied once per presented frame, not per instruction,
+// so it works in the same way with any frontend and headless capture
+type DisplayFilter struct {
+	fade      int  // number of frames while turned off pixel decays. 0 turns off immediately
+	deflicker bool // pixel is lit if it is lit in the current or the previous frame
+	prev      Screen
+	frame     Frame
+}
+
+func NewDisplayFilter(options DisplayOptions) *DisplayFilter {
+	return &DisplayFilter{fade: options.Fade, deflicker: options.Deflicker}
+}
+
+// Apply returns intensity of the next frame
+func (f *DisplayFilter) Apply(screen *Screen) *Frame {
+	step := maxIntensity/(f.fade+1) + 1
+	for i, pixel := range screen {
+		lit := pixel != 0 || (f.deflicker && f.prev[i] != 0)
+		if lit {
+			f.frame[i] = maxIntensity
+		} else {
+			f.frame[i] = uint8(max(0, int(f.frame[i])-step))
+		}
+	}
+	f.prev = *screen
+	return &f.frame
+}
+
+const frameInterval = time.Second / 60
+
+// frameTicker decides when a frame is presented. Draw is called after each instruction,
+// but display filters advance by frame. frames are on fixed 1/60 s steps, so the rate does not
+// depend on how often Draw is called
+type frameTicker struct {
+	next time.Time // time of the next frame
+}
+
+// advance returns the number of frames which became due until now
+func (t *frameTicker) advance(now time.Time) int {
+	if t.next.IsZero() {
+		t.next = now
+	}
+	if now.Before(t.next) {
+		return 0
+	}
+	frames := int(now.Sub(t.next)/frameInterval) + 1
+	t.next = t.next.Add(time.Duration(frames) * frameInterval)
+	return frames
+}
+
+// due reports whether the next frame should be presented now. frames missed by more than one step are skipped
+func (t *frameTicker) due() bool {
+	return t.advance(time.Now()) > 0
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFrameTickerRate(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval time.Duration // interval of Draw calls which present frames
+	}{
+		{"pacer wakeup", time.Second * time.Duration(defaultInstructionRate/60) / defaultInstructionRate},
+		{"every instruction", time.Second / defaultInstructionRate},
+		{"faster than frame", frameInterval - time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ticker frameTicker
+			start := time.Now()
+			presented := 0
+			for elapsed := time.Duration(0); elapsed <= 3*time.Second; elapsed += tt.interval {
+				if ticker.advance(start.Add(elapsed)) > 0 {
+					presented++
+				}
+			}
+			if presented < 179 || presented > 181 {
+				t.Errorf("presented %d frames in 3 seconds, want 180", presented)
+			}
+		})
+	}
+}
+
+func TestFrameTickerCatchUp(t *testing.T) {
+	var ticker frameTicker
+	start := time.Now()
+	if frames := ticker.advance(start); frames != 1 {
+		t.Fatalf("first frame: got %d, want 1", frames)
+	}
+	if frames := ticker.advance(start.Add(frameInterval / 2)); frames != 0 {
+		t.Errorf("before next frame: got %d, want 0", frames)
+	}
+	if frames := ticker.advance(start.Add(frameInterval*3 + frameInterval/2)); frames != 3 {
+		t.Errorf("after 3 frames: got %d, want 3", frames)
+	}
+	if frames := ticker.advance(start.Add(frameInterval * 4)); frames != 1 {
+		t.Errorf("next frame stays on fixed step: got %d, want 1", frames)
+	}
+}
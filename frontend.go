@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Frontend is a Device which owns window, terminal or connection
+type Frontend interface {
+	Device
+	Setup() error
+	Teardown()
+}
+
+// FrontendOptions are resolved from config and flags
+type FrontendOptions struct {
+	Keymap  *Keymap
+	Gamepad *GamepadMap
+	Display DisplayOptions
+}
+
+// NewFrontend create frontend from NAME[:ARG] (ex. sdl, term, term:braille, headless, web:127.0.0.1:8080, vnc:5900, vnc:0.0.0.0:5900)
+func NewFrontend(spec string, options FrontendOptions) (Frontend, error) {
+	name, arg, _ := strings.Cut(spec, ":")
+	switch name {
+	case "sdl":
+		return NewSDLDevice(options), nil
+	case "term":
+		return NewTermDevice(arg, options)
+	case "vnc":
+		return NewVNCDevice(arg, options), nil
+	case "web":
+		return NewWebDevice(arg, options), nil
+	case "headless":
+		return &HeadlessDevice{pacer: instructionPacer{rate: defaultInstructionRate}}, nil
+	}
+	return nil, fmt.Errorf("unknown frontend: %s (sdl, term, term:braille, headless, web, vnc are supported)", spec)
+}
+
+// HeadlessDevice runs VM in real time without display and input. it is used with capture options
+type HeadlessDevice struct {
+	pacer instructionPacer
+}
+
+func (h *HeadlessDevice) Setup() error {
+	return nil
+}
+
+func (h *HeadlessDevice) Teardown() {
+}
+
+func (h *HeadlessDevice) Draw(screen *Screen) error {
+	h.pacer.wait()
+	return nil
+}
+
+func (h *HeadlessDevice) PollKey(keypad *Keypad) bool {
+	return true
+}
+
+const defaultInstructionRate = 700 // instructions per second
+
+// instructionPacer limits instruction rate of VM. Device.Draw is called after each instruction,
+// so frontends without vsync call wait from Draw to keep games playable
+type instructionPacer struct {
+	rate  int
+	count int
+	start time.Time
+}
+
+func (p *instructionPacer) wait() {
+	now := time.Now()
+	if p.start.IsZero() {
+		p.start = now
+	}
+	p.count++
+	if p.count%(p.rate/60) != 0 { // sleep once per frame
+		return
+	}
+	expected := time.Duration(p.count) * time.Second / time.Duration(p.rate)
+	elapsed := now.Sub(p.start)
+	if elapsed-expected > 100*time.Millisecond { // too slow to catch up
+		p.start, p.count = now, 0
+		return
+	}
+	if expected > elapsed {
+		time.Sleep(expected - elapsed)
+	}
+}
+
+// remoteKeyEvent is key event sent by client of network frontend. name is key name of Keymap
+type remoteKeyEvent struct {
+	name string
+	down bool
+}
+
+// remoteKeys passes key events of network clients to VM. quit key is not handled,
+// so remote clients cannot stop the emulator
+type remoteKeys struct {
+	keymap *Keymap
+	events chan remoteKeyEvent
+}
+
+func newRemoteKeys(keymap *Keymap) remoteKeys {
+	return remoteKeys{keymap: keymap, events: make(chan remoteKeyEvent, 64)}
+}
+
+// send queue key event of the client. held records keys pressed by the client
+func (r *remoteKeys) send(held map[string]bool, name string, down bool) {
+	if down {
+		held[name] = true
+	} else {
+		delete(held, name)
+	}
+	r.events <- remoteKeyEvent{name: name, down: down}
+}
+
+// releaseAll release keys of the leaving client
+func (r *remoteKeys) releaseAll(held map[string]bool) {
+	for name := range held {
+		r.send(held, name, false)
+	}
+}
+
+// poll apply queued key events to keypad
+func (r *remoteKeys) poll(keypad *Keypad) {
+	for {
+		select {
+		case event := <-r.events:
+			key, ok := r.keymap.Lookup(event.name)
+			if !ok {
+				continue
+			}
+			if !event.down {
+				keypad.Release(key)
+			} else if keypad.IsEmpty() { // only allow one key
+				keypad.Press(key)
+			}
+		default:
+			return
+		}
+	}
+}
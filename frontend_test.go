@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestRemoteKeys(t *testing.T) {
+	keymap, err := (&Config{}).NewKeymap(&Settings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := newRemoteKeys(keymap)
+	held := make(map[string]bool)
+	var keypad Keypad
+	keys.send(held, "x", true)
+	keys.send(held, "1", true) // only one key is allowed
+	keys.poll(&keypad)
+	if key, _ := keymap.Lookup("x"); !keypad.IsPressed(key) {
+		t.Errorf("x is not pressed")
+	}
+	if key, _ := keymap.Lookup("1"); keypad.IsPressed(key) {
+		t.Errorf("1 is pressed with another key")
+	}
+	keys.releaseAll(held)
+	keys.poll(&keypad)
+	if !keypad.IsEmpty() || len(held) != 0 {
+		t.Errorf("keys of leaving client are not released")
+	}
+}
+
+func TestRemoteQuitKeyIsIgnored(t *testing.T) {
+	keymap, err := (&Config{}).NewKeymap(&Settings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := FrontendOptions{Keymap: keymap}
+	for name, device := range map[string]Device{"web": NewWebDevice("", options), "vnc": NewVNCDevice("", options)} {
+		var keys *remoteKeys
+		switch device := device.(type) {
+		case *WebDevice:
+			keys = &device.keys
+		case *VNCDevice:
+			keys = &device.keys
+		}
+		keys.send(make(map[string]bool), keymap.QuitKey(), true)
+		if !device.PollKey(&Keypad{}) {
+			t.Errorf("%s: quit key of remote client stops the emulator", name)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// button names of SDL GameController in order of SDL_GameControllerButton. left stick is treated as D-pad
+var gamepadButtonNames = []string{
+	"a", "b", "x", "y", "back", "guide", "start", "leftstick", "rightstick", "leftshoulder", "rightshoulder",
+	"dpup", "dpdown", "dpleft", "dpright",
+}
+
+const defaultGamepadMap = "octo"
+
+// presets for common layouts of CHIP-8 games
+var gamepadPresets = map[string]map[string]string{
+	"octo": { // WASD of Octo keyboard layout, E and Q for action
+		"dpup": "5", "dpleft": "7", "dpdown": "8", "dpright": "9", "a": "6", "b": "4", "start": "F",
+	},
+	"numeric": { // arrows on numeric keypad, 5 for action
+		"dpup": "2", "dpleft": "4", "dpdown": "8", "dpright": "6", "a": "5", "b": "0", "start": "F",
+	},
+	"paddles": { // two players of pong-like games
+		"dpup": "1", "dpdown": "4", "x": "C", "a": "D",
+	},
+}
+
+// GamepadMap binds gamepad button names to CHIP-8 keys
+type GamepadMap struct {
+	Name    string
+	buttons map[string]uint8
+}
+
+// NewGamepadMap build mapping from preset or mapping defined in config, then apply button bindings of settings
+func (c *Config) NewGamepadMap(settings *Settings) (*GamepadMap, error) {
+	name := settings.Gamepad
+	if name == "" {
+		name = defaultGamepadMap
+	}
+	buttons, ok := c.Gamepads[name]
+	if !ok {
+		if buttons, ok = gamepadPresets[name]; !ok {
+			return nil, fmt.Errorf("unknown gamepad mapping: %s", name)
+		}
+	}
+	g := &GamepadMap{Name: name, buttons: make(map[string]uint8)}
+	if err := g.bind(buttons); err != nil {
+		return nil, fmt.Errorf("gamepad mapping %s: %v", name, err)
+	}
+	if err := g.bind(settings.Buttons); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// bind update bindings by button name to hex digit. empty or "none" unbinds the button
+func (g *GamepadMap) bind(buttons map[string]string) error {
+	for name, value := range buttons {
+		name = strings.ToLower(name)
+		if !slices.Contains(gamepadButtonNames, name) {
+			return fmt.Errorf("unknown gamepad button: %s (%s)", name, strings.Join(gamepadButtonNames, ", "))
+		}
+		if value == "" || value == "none" {
+			delete(g.buttons, name)
+			continue
+		}
+		key, err := parseKeypadKey(value)
+		if err != nil {
+			return fmt.Errorf("%v: %s = %s", err, name, value)
+		}
+		g.buttons[name] = key
+	}
+	return nil
+}
+
+// Lookup returns CHIP-8 key bound to the button name
+func (g *GamepadMap) Lookup(button string) (uint8, bool) {
+	key, ok := g.buttons[button]
+	return key, ok
+}
+
+// WriteGrid write button names bound to each CHIP-8 key, placed on the keypad grid
+func (g *GamepadMap) WriteGrid(writer io.Writer) error {
+	return writeKeypadGrid(writer, "gamepad: "+g.Name, g.buttons)
+}
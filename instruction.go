@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"io"
+	"strings"
 )
 
 type InstructionPrinter struct {
@@ -252,6 +253,223 @@ var InstructionTypeNames = map[InstructionType]string{
 	OP_FX65: "LD",
 }
 
+type OperandKind uint8
+
+const (
+	OPERAND_REG    OperandKind = iota // Vx, Vy
+	OPERAND_V0                        // V0 (fixed register)
+	OPERAND_ADDR                      // NNN
+	OPERAND_BYTE                      // NN
+	OPERAND_NIBBLE                    // N
+	OPERAND_I                         // I
+	OPERAND_IND_I                     // [I]
+	OPERAND_DT                        // delay timer
+	OPERAND_ST                        // sound timer
+	OPERAND_K                         // key
+	OPERAND_F                         // font sprite
+	OPERAND_B                         // BCD
+)
+
+var operandKeywords = map[OperandKind]string{
+	OPERAND_V0:    "V0",
+	OPERAND_I:     "I",
+	OPERAND_IND_I: "[I]",
+	OPERAND_DT:    "DT",
+	OPERAND_ST:    "ST",
+	OPERAND_K:     "K",
+	OPERAND_F:     "F",
+	OPERAND_B:     "B",
+}
+
+// InstructionOperandForms maintains operand order of each instruction.
+// first OPERAND_REG is encoded as X, second one is encoded as Y
+var InstructionOperandForms = map[InstructionType][]OperandKind{
+	OP_0NNN: {OPERAND_ADDR},
+	OP_00E0: {},
+	OP_00EE: {},
+	OP_1NNN: {OPERAND_ADDR},
+	OP_2NNN: {OPERAND_ADDR},
+	OP_3XNN: {OPERAND_REG, OPERAND_BYTE},
+	OP_4XNN: {OPERAND_REG, OPERAND_BYTE},
+	OP_5XY0: {OPERAND_REG, OPERAND_REG},
+	OP_6XNN: {OPERAND_REG, OPERAND_BYTE},
+	OP_7XNN: {OPERAND_REG, OPERAND_BYTE},
+	OP_8XY0: {OPERAND_REG, OPERAND_REG},
+	OP_8XY1: {OPERAND_REG, OPERAND_REG},
+	OP_8XY2: {OPERAND_REG, OPERAND_REG},
+	OP_8XY3: {OPERAND_REG, OPERAND_REG},
+	OP_8XY4: {OPERAND_REG, OPERAND_REG},
+	OP_8XY5: {OPERAND_REG, OPERAND_REG},
+	OP_8XY6: {OPERAND_REG, OPERAND_REG},
+	OP_8XY7: {OPERAND_REG, OPERAND_REG},
+	OP_8XYE: {OPERAND_REG, OPERAND_REG},
+	OP_9XY0: {OPERAND_REG, OPERAND_REG},
+	OP_ANNN: {OPERAND_I, OPERAND_ADDR},
+	OP_BNNN: {OPERAND_V0, OPERAND_ADDR},
+	OP_CXNN: {OPERAND_REG, OPERAND_BYTE},
+	OP_DXYN: {OPERAND_REG, OPERAND_REG, OPERAND_NIBBLE},
+	OP_EX9E: {OPERAND_REG},
+	OP_EXA1: {OPERAND_REG},
+	OP_FX07: {OPERAND_REG, OPERAND_DT},
+	OP_FX0A: {OPERAND_REG, OPERAND_K},
+	OP_FX15: {OPERAND_DT, OPERAND_REG},
+	OP_FX18: {OPERAND_ST, OPERAND_REG},
+	OP_FX1E: {OPERAND_I, OPERAND_REG},
+	OP_FX29: {OPERAND_F, OPERAND_REG},
+	OP_FX33: {OPERAND_B, OPERAND_REG},
+	OP_FX55: {OPERAND_IND_I, OPERAND_REG},
+	OP_FX65: {OPERAND_REG, OPERAND_IND_I},
+}
+
+var instructionOpcodes = map[InstructionType]uint16{
+	OP_0NNN: 0x0000,
+	OP_00E0: 0x00E0,
+	OP_00EE: 0x00EE,
+	OP_1NNN: 0x1000,
+	OP_2NNN: 0x2000,
+	OP_3XNN: 0x3000,
+	OP_4XNN: 0x4000,
+	OP_5XY0: 0x5000,
+	OP_6XNN: 0x6000,
+	OP_7XNN: 0x7000,
+	OP_8XY0: 0x8000,
+	OP_8XY1: 0x8001,
+	OP_8XY2: 0x8002,
+	OP_8XY3: 0x8003,
+	OP_8XY4: 0x8004,
+	OP_8XY5: 0x8005,
+	OP_8XY6: 0x8006,
+	OP_8XY7: 0x8007,
+	OP_8XYE: 0x800E,
+	OP_9XY0: 0x9000,
+	OP_ANNN: 0xA000,
+	OP_BNNN: 0xB000,
+	OP_CXNN: 0xC000,
+	OP_DXYN: 0xD000,
+	OP_EX9E: 0xE09E,
+	OP_EXA1: 0xE0A1,
+	OP_FX07: 0xF007,
+	OP_FX0A: 0xF00A,
+	OP_FX15: 0xF015,
+	OP_FX18: 0xF018,
+	OP_FX1E: 0xF01E,
+	OP_FX29: 0xF029,
+	OP_FX33: 0xF033,
+	OP_FX55: 0xF055,
+	OP_FX65: 0xF065,
+}
+
+// InstructionDescriptions maintains semantics of each instruction
+var InstructionDescriptions = map[InstructionType]string{
+	OP_0NNN: "Call machine code routine at NNN (ignored)",
+	OP_00E0: "Clear the screen",
+	OP_00EE: "Return from subroutine",
+	OP_1NNN: "Jump to NNN",
+	OP_2NNN: "Call subroutine at NNN",
+	OP_3XNN: "Skip next instruction if Vx == NN",
+	OP_4XNN: "Skip next instruction if Vx != NN",
+	OP_5XY0: "Skip next instruction if Vx == Vy",
+	OP_6XNN: "Vx = NN",
+	OP_7XNN: "Vx += NN (VF is not changed)",
+	OP_8XY0: "Vx = Vy",
+	OP_8XY1: "Vx |= Vy",
+	OP_8XY2: "Vx &= Vy",
+	OP_8XY3: "Vx ^= Vy",
+	OP_8XY4: "Vx += Vy, VF = carry",
+	OP_8XY5: "Vx -= Vy, VF = not borrow",
+	OP_8XY6: "Vx >>= 1, VF = shifted out bit",
+	OP_8XY7: "Vx = Vy - Vx, VF = not borrow",
+	OP_8XYE: "Vx <<= 1, VF = shifted out bit",
+	OP_9XY0: "Skip next instruction if Vx != Vy",
+	OP_ANNN: "I = NNN",
+	OP_BNNN: "Jump to NNN + V0",
+	OP_CXNN: "Vx = random byte & NN",
+	OP_DXYN: "Draw N bytes sprite from I at (Vx, Vy), VF = collision",
+	OP_EX9E: "Skip next instruction if key Vx is pressed",
+	OP_EXA1: "Skip next instruction if key Vx is not pressed",
+	OP_FX07: "Vx = delay timer",
+	OP_FX0A: "Wait key press and release, then Vx = key",
+	OP_FX15: "delay timer = Vx",
+	OP_FX18: "sound timer = Vx",
+	OP_FX1E: "I += Vx",
+	OP_FX29: "I = address of font sprite of digit Vx",
+	OP_FX33: "Store BCD of Vx to I, I+1 and I+2",
+	OP_FX55: "Store V0 to Vx to memory from I",
+	OP_FX65: "Load V0 to Vx from memory from I",
+}
+
+// InstructionEncoding returns opcode pattern such as `8XY4`
+func InstructionEncoding(op InstructionType) string {
+	pattern := []byte(fmt.Sprintf("%04X", instructionOpcodes[op]))
+	regs := 0
+	for _, kind := range InstructionOperandForms[op] {
+		switch kind {
+		case OPERAND_REG:
+			pattern[1+regs] = "XY"[regs]
+			regs++
+		case OPERAND_ADDR:
+			copy(pattern[1:], "NNN")
+		case OPERAND_BYTE:
+			copy(pattern[2:], "NN")
+		case OPERAND_NIBBLE:
+			pattern[3] = 'N'
+		}
+	}
+	return string(pattern)
+}
+
+// InstructionSyntax returns assembly syntax such as `ADD Vx, Vy`
+func InstructionSyntax(op InstructionType) string {
+	var operands []string
+	regs := 0
+	for _, kind := range InstructionOperandForms[op] {
+		switch kind {
+		case OPERAND_REG:
+			operands = append(operands, "V"+"xy"[regs:regs+1])
+			regs++
+		case OPERAND_ADDR:
+			operands = append(operands, "NNN")
+		case OPERAND_BYTE:
+			operands = append(operands, "NN")
+		case OPERAND_NIBBLE:
+			operands = append(operands, "N")
+		default:
+			operands = append(operands, operandKeywords[kind])
+		}
+	}
+	return strings.TrimSpace(InstructionTypeNames[op] + " " + strings.Join(operands, ", "))
+}
+
+// EncodeInstruction build opcode word from registers (X, Y) and constant (NNN, NN or N).
+// operands must be in range
+func EncodeInstruction(op InstructionType, regs []uint8, value uint16) uint16 {
+	word := instructionOpcodes[op]
+	if len(regs) > 0 {
+		word |= uint16(regs[0]&0xf) << 8
+	}
+	if len(regs) > 1 {
+		word |= uint16(regs[1]&0xf) << 4
+	}
+	return word | value&0xfff
+}
+
+// format operands following InstructionOperandForms
+func formatOperands(op InstructionType, regs []uint8, value string) string {
+	var operands []string
+	for _, kind := range InstructionOperandForms[op] {
+		switch kind {
+		case OPERAND_REG:
+			operands = append(operands, fmt.Sprintf("V%d", regs[0]))
+			regs = regs[1:]
+		case OPERAND_ADDR, OPERAND_BYTE, OPERAND_NIBBLE:
+			operands = append(operands, value)
+		default:
+			operands = append(operands, operandKeywords[kind])
+		}
+	}
+	return strings.Join(operands, ", ")
+}
+
 // AddrIns follow `0nnn` form
 type AddrIns struct {
 	addr   uint16
@@ -280,7 +498,7 @@ func (a AddrIns) Print(printer InstructionPrinter) (err error) {
 	if !ok {
 		v = fmt.Sprintf("@0x%03x", a.target)
 	}
-	_, err = fmt.Fprintf(printer.writer, "    %-4s  %s\n", InstructionTypeNames[a.op], v)
+	_, err = fmt.Fprintf(printer.writer, "    %-4s  %s\n", InstructionTypeNames[a.op], formatOperands(a.op, nil, v))
 	return
 }
 
@@ -333,7 +551,7 @@ func (o OneRegIns) Address() uint16 {
 }
 
 func (o OneRegIns) Print(printer InstructionPrinter) (err error) {
-	_, err = fmt.Fprintf(printer.writer, "    %-4s  V%d\n", InstructionTypeNames[o.op], o.reg)
+	_, err = fmt.Fprintf(printer.writer, "    %-4s  %s\n", InstructionTypeNames[o.op], formatOperands(o.op, []uint8{o.reg}, ""))
 	return
 }
 
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+original keycode
+1 2 3 C
+4 5 6 D
+7 8 9 E
+A 0 B F
+
+physical keycode (qwerty)
+1 2 3 4
+q w e r
+a s d f
+z x c v
+*/
+var keypadGrid = [4][4]uint8{
+	{0x1, 0x2, 0x3, 0xC},
+	{0x4, 0x5, 0x6, 0xD},
+	{0x7, 0x8, 0x9, 0xE},
+	{0xA, 0x0, 0xB, 0xF},
+}
+
+// key names other than single character
+var specialKeyNames = []string{
+	"escape", "space", "enter", "tab", "backspace", "up", "down", "left", "right",
+	"kp0", "kp1", "kp2", "kp3", "kp4", "kp5", "kp6", "kp7", "kp8", "kp9",
+	"kp/", "kp*", "kp-", "kp+", "kp.", "kpenter",
+	"f1", "f2", "f3", "f4", "f5", "f6", "f7", "f8", "f9", "f10", "f11", "f12",
+}
+
+const (
+	defaultKeymap  = "qwerty"
+	defaultQuitKey = "escape"
+)
+
+// KeymapConfig defines keymap by physical key names placed on the keypad grid
+type KeymapConfig struct {
+	Rows [][]string        `json:"rows,omitempty"` // 4 rows of 4 key names for 123C / 456D / 789E / A0BF
+	Keys map[string]string `json:"keys,omitempty"` // additional bindings of key name to CHIP-8 key
+	Quit string            `json:"quit,omitempty"`
+}
+
+var keymapPresets = map[string]*KeymapConfig{
+	"qwerty": {Rows: [][]string{
+		{"1", "2", "3", "4"},
+		{"q", "w", "e", "r"},
+		{"a", "s", "d", "f"},
+		{"z", "x", "c", "v"},
+	}},
+	"azerty": {Rows: [][]string{
+		{"&", "é", "\"", "'"},
+		{"a", "z", "e", "r"},
+		{"q", "s", "d", "f"},
+		{"w", "x", "c", "v"},
+	}},
+	"numpad": {Rows: [][]string{
+		{"kp7", "kp8", "kp9", "kp/"},
+		{"kp4", "kp5", "kp6", "kp*"},
+		{"kp1", "kp2", "kp3", "kp-"},
+		{"kp0", "kp.", "kpenter", "kp+"},
+	}},
+}
+
+// Keymap binds frontend-independent key names to CHIP-8 keys
+type Keymap struct {
+	Name string
+	keys map[string]uint8
+	quit string
+}
+
+// normalizeKeyName returns lower case key name, or error if it is unknown
+func normalizeKeyName(name string) (string, error) {
+	if utf8.RuneCountInString(name) == 1 {
+		return strings.ToLower(name), nil
+	}
+	lower := strings.ToLower(name)
+	if slices.Contains(specialKeyNames, lower) {
+		return lower, nil
+	}
+	return "", fmt.Errorf("unknown key name: %s", name)
+}
+
+func parseKeypadKey(s string) (uint8, error) {
+	key, err := strconv.ParseUint(s, 16, 8)
+	if err != nil || key >= KeyNum {
+		return 0, fmt.Errorf("CHIP-8 key must be hex digit (0-F)")
+	}
+	return uint8(key), nil
+}
+
+// bind update bindings by key name to hex digit. empty or "none" unbinds the key
+func (k *Keymap) bind(keys map[string]string) error {
+	for name, value := range keys {
+		name, err := normalizeKeyName(name)
+		if err != nil {
+			return err
+		}
+		if value == "" || value == "none" {
+			delete(k.keys, name)
+			continue
+		}
+		key, err := parseKeypadKey(value)
+		if err != nil {
+			return fmt.Errorf("%v: %s = %s", err, name, value)
+		}
+		k.keys[name] = key
+	}
+	return nil
+}
+
+func (k *Keymap) setQuit(name string) error {
+	if name == "" {
+		return nil
+	}
+	name, err := normalizeKeyName(name)
+	if err != nil {
+		return err
+	}
+	k.quit = name
+	return nil
+}
+
+// NewKeymap build keymap from preset or keymap defined in config, then apply key bindings of settings
+func (c *Config) NewKeymap(settings *Settings) (*Keymap, error) {
+	name := settings.Keymap
+	if name == "" {
+		name = defaultKeymap
+	}
+	keymapConfig, ok := c.Keymaps[name]
+	if !ok {
+		if keymapConfig, ok = keymapPresets[name]; !ok {
+			return nil, fmt.Errorf("unknown keymap: %s", name)
+		}
+	}
+	if len(keymapConfig.Rows) != 0 && len(keymapConfig.Rows) != 4 {
+		return nil, fmt.Errorf("keymap %s: rows must be 4 rows of 4 key names", name)
+	}
+	k := &Keymap{Name: name, keys: make(map[string]uint8), quit: defaultQuitKey}
+	for y, row := range keymapConfig.Rows {
+		if len(row) != 4 {
+			return nil, fmt.Errorf("keymap %s: rows must be 4 rows of 4 key names", name)
+		}
+		for x, keyName := range row {
+			if err := k.bind(map[string]string{keyName: strconv.FormatUint(uint64(keypadGrid[y][x]), 16)}); err != nil {
+				return nil, fmt.Errorf("keymap %s: %v", name, err)
+			}
+		}
+	}
+	if err := k.bind(keymapConfig.Keys); err != nil {
+		return nil, fmt.Errorf("keymap %s: %v", name, err)
+	}
+	if err := k.setQuit(keymapConfig.Quit); err != nil {
+		return nil, fmt.Errorf("keymap %s: %v", name, err)
+	}
+	if err := k.bind(settings.Keys); err != nil {
+		return nil, err
+	}
+	if err := k.setQuit(settings.Quit); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Lookup returns CHIP-8 key bound to the key name
+func (k *Keymap) Lookup(name string) (uint8, bool) {
+	key, ok := k.keys[name]
+	return key, ok
+}
+
+// IsQuit reports whether the key name is bound to quit
+func (k *Keymap) IsQuit(name string) bool {
+	return name == k.quit
+}
+
+// Bindings returns key names bound to any CHIP-8 key
+func (k *Keymap) Bindings() map[string]uint8 {
+	return k.keys
+}
+
+// QuitKey returns key name to quit
+func (k *Keymap) QuitKey() string {
+	return k.quit
+}
+
+// WriteGrid write key names bound to each CHIP-8 key, placed on the keypad grid
+func (k *Keymap) WriteGrid(writer io.Writer) error {
+	if err := writeKeypadGrid(writer, "keymap: "+k.Name, k.keys); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(writer, "quit: %s\n", k.quit)
+	return err
+}
+
+func writeKeypadGrid(writer io.Writer, title string, bindings map[string]uint8) error {
+	names := make(map[uint8][]string)
+	for name, key := range bindings {
+		names[key] = append(names[key], name)
+	}
+	width := 1
+	for key := range names {
+		slices.Sort(names[key])
+		width = max(width, utf8.RuneCountInString(strings.Join(names[key], " ")))
+	}
+	_, _ = fmt.Fprintln(writer, title)
+	for _, row := range keypadGrid {
+		var cells []string
+		for _, key := range row {
+			bound := strings.Join(names[key], " ")
+			if bound == "" {
+				bound = "-"
+			}
+			padding := strings.Repeat(" ", width-utf8.RuneCountInString(bound))
+			cells = append(cells, fmt.Sprintf("%X [%s%s]", key, bound, padding))
+		}
+		if _, err := fmt.Fprintln(writer, strings.Join(cells, "  ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
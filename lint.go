@@ -0,0 +1,456 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+const StackSize = 16
+
+// LintFinding is a likely bug found by static analysis
+type LintFinding struct {
+	Address  uint16   `json:"address"`
+	Severity Severity `json:"severity"`
+	Check    string   `json:"check"`
+	Message  string   `json:"message"`
+}
+
+func (f *LintFinding) String() string {
+	return fmt.Sprintf("0x%03X: %s: %s [%s]", f.Address, f.Severity, f.Message, f.Check)
+}
+
+type LintFindings []LintFinding
+
+func (f LintFindings) Count(severity Severity) int {
+	count := 0
+	for _, finding := range f {
+		if finding.Severity == severity {
+			count++
+		}
+	}
+	return count
+}
+
+func (f LintFindings) WriteText(writer io.Writer) error {
+	for _, finding := range f {
+		if _, err := fmt.Fprintln(writer, finding.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f LintFindings) WriteJSON(writer io.Writer) error {
+	if f == nil {
+		f = LintFindings{}
+	}
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(f)
+}
+
+// index register state of dataflow analysis
+type lintIState uint8
+
+const (
+	I_UNSET lintIState = iota
+	I_KNOWN
+	I_UNKNOWN
+)
+
+type lintState struct {
+	reached bool
+	iState  lintIState
+	i       uint16
+	init    uint16 // bit set of definitely written registers
+}
+
+func (s lintState) merge(other lintState) lintState {
+	if !s.reached {
+		return other
+	}
+	if !other.reached {
+		return s
+	}
+	merged := lintState{reached: true, iState: s.iState, i: s.i, init: s.init & other.init}
+	if s.iState != other.iState || (s.iState == I_KNOWN && s.i != other.i) {
+		merged.iState = I_UNKNOWN
+	}
+	return merged
+}
+
+type linter struct {
+	buf        []byte
+	annotation *Annotation
+	cfg        *CFG
+	code       map[uint16]bool // addresses of reachable instruction bytes
+	subs       map[uint16]*Subroutine
+	mayWrite   map[uint16]uint16 // registers may be written by subroutine including callees
+	mayWriteI  map[uint16]bool
+	findings   LintFindings
+	reported   map[string]bool
+}
+
+func (l *linter) report(addr uint16, severity Severity, check string, format string, args ...any) {
+	finding := LintFinding{Address: addr, Severity: severity, Check: check, Message: fmt.Sprintf(format, args...)}
+	if key := finding.String(); !l.reported[key] { // shared blocks are analyzed per subroutine
+		l.reported[key] = true
+		l.findings = append(l.findings, finding)
+	}
+}
+
+func (l *linter) forEachInstruction(sub *Subroutine, callback func(ins Instruction)) {
+	for _, b := range sub.blocks {
+		for _, ins := range b.instructions {
+			callback(ins)
+		}
+	}
+}
+
+// registerUsage returns bit sets of registers read and written by instruction
+func registerUsage(ins Instruction) (reads uint16, writes uint16) {
+	const vf = 1 << 0xF
+	switch ins := ins.(type) {
+	case OneRegConstIns:
+		x := uint16(1) << ins.reg
+		switch ins.op {
+		case OP_3XNN, OP_4XNN:
+			return x, 0
+		case OP_6XNN, OP_CXNN:
+			return 0, x
+		case OP_7XNN:
+			return x, x
+		}
+	case TwoRegIns:
+		x, y := uint16(1)<<ins.reg1, uint16(1)<<ins.reg2
+		switch ins.op {
+		case OP_5XY0, OP_9XY0:
+			return x | y, 0
+		case OP_8XY0:
+			return y, x
+		case OP_8XY1, OP_8XY2, OP_8XY3:
+			return x | y, x
+		case OP_8XY4, OP_8XY5, OP_8XY7:
+			return x | y, x | vf
+		case OP_8XY6, OP_8XYE:
+			return x, x | vf
+		}
+	case TwoRegConstIns:
+		return 1<<ins.reg1 | 1<<ins.reg2, vf
+	case OneRegIns:
+		x := uint16(1) << ins.reg
+		all := uint16(1)<<(ins.reg+1) - 1 // V0 to Vx
+		switch ins.op {
+		case OP_FX07, OP_FX0A:
+			return 0, x
+		case OP_FX55:
+			return all, 0
+		case OP_FX65:
+			return 0, all
+		default: // SKP, SKNP, LD DT/ST/F/B, ADD I
+			return x, 0
+		}
+	case AddrIns:
+		if ins.op == OP_BNNN {
+			return 1, 0
+		}
+	}
+	return 0, 0
+}
+
+func (l *linter) registerNames(set uint16) string {
+	var names []string
+	for i := 0; i < 16; i++ {
+		if set&(1<<i) != 0 {
+			names = append(names, fmt.Sprintf("V%X", i))
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// computeMayWrite compute registers written by each subroutine and its callees until fixpoint
+func (l *linter) computeMayWrite() {
+	for _, sub := range l.cfg.subroutines {
+		l.forEachInstruction(sub, func(ins Instruction) {
+			_, writes := registerUsage(ins)
+			l.mayWrite[sub.entry] |= writes
+			switch ins.Type() {
+			case OP_ANNN, OP_FX1E, OP_FX29:
+				l.mayWriteI[sub.entry] = true
+			}
+		})
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, sub := range l.cfg.subroutines {
+			for _, callee := range sub.calls {
+				writes := l.mayWrite[sub.entry] | l.mayWrite[callee]
+				writeI := l.mayWriteI[sub.entry] || l.mayWriteI[callee]
+				if writes != l.mayWrite[sub.entry] || writeI != l.mayWriteI[sub.entry] {
+					l.mayWrite[sub.entry], l.mayWriteI[sub.entry] = writes, writeI
+					changed = true
+				}
+			}
+		}
+	}
+}
+
+func (l *linter) checkCallDepth() {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[uint16]int)
+	depth := make(map[uint16]int) // max number of stack entries used by calls from the subroutine
+	next := make(map[uint16]uint16)
+	var path []uint16
+	var visit func(entry uint16)
+	visit = func(entry uint16) {
+		state[entry] = visiting
+		path = append(path, entry)
+		sub := l.subs[entry]
+		l.forEachInstruction(sub, func(ins Instruction) {
+			if ins.Type() != OP_2NNN {
+				return
+			}
+			callee := ins.(AddrIns).target
+			if _, ok := l.subs[callee]; !ok {
+				return
+			}
+			switch state[callee] {
+			case visiting:
+				var names []string
+				for i := len(path) - 1; i >= 0; i-- {
+					names = append([]string{l.cfg.name(path[i])}, names...)
+					if path[i] == callee {
+						break
+					}
+				}
+				l.report(ins.Address(), SEVERITY_ERROR, "recursion",
+					"recursive call %s -> %s may overflow %d-entry stack",
+					strings.Join(names, " -> "), l.cfg.name(callee), StackSize)
+				return
+			case unvisited:
+				visit(callee)
+			}
+			if depth[callee]+1 > depth[entry] {
+				depth[entry] = depth[callee] + 1
+				next[entry] = callee
+			}
+		})
+		path = path[:len(path)-1]
+		state[entry] = visited
+	}
+	visit(Chip8ProgStartAddr)
+
+	if depth[Chip8ProgStartAddr] > StackSize {
+		names := []string{l.cfg.name(Chip8ProgStartAddr)}
+		for entry := uint16(Chip8ProgStartAddr); depth[entry] > 0; entry = next[entry] {
+			names = append(names, l.cfg.name(next[entry]))
+		}
+		l.report(Chip8ProgStartAddr, SEVERITY_ERROR, "stack-depth", "call depth %d exceeds %d-entry stack: %s",
+			depth[Chip8ProgStartAddr], StackSize, strings.Join(names, " -> "))
+	}
+}
+
+func (l *linter) checkReturnWithoutCall() {
+	l.forEachInstruction(l.subs[Chip8ProgStartAddr], func(ins Instruction) {
+		if ins.Type() == OP_00EE {
+			l.report(ins.Address(), SEVERITY_ERROR, "ret-without-call", "RET is reachable from program entry without CALL")
+		}
+	})
+}
+
+func (l *linter) checkJumpTargets() {
+	end := Chip8ProgStartAddr + len(l.buf)
+	for _, sub := range l.cfg.subroutines {
+		l.forEachInstruction(sub, func(ins Instruction) {
+			addrIns, ok := ins.(AddrIns)
+			if !ok || (addrIns.op != OP_1NNN && addrIns.op != OP_2NNN && addrIns.op != OP_BNNN) {
+				return
+			}
+			target := addrIns.target
+			name := InstructionTypeNames[addrIns.op]
+			switch {
+			case int(target) < Chip8ProgStartAddr || int(target) >= end:
+				if addrIns.op != OP_BNNN {
+					l.report(ins.Address(), SEVERITY_ERROR, "jump-target", "%s to 0x%03X outside of program", name, target)
+				}
+			case l.annotation.isData(target):
+				l.report(ins.Address(), SEVERITY_ERROR, "jump-target", "%s into data at 0x%03X", name, target)
+			case target%2 != 0:
+				l.report(ins.Address(), SEVERITY_WARNING, "jump-target", "%s to odd address 0x%03X", name, target)
+			}
+		})
+	}
+}
+
+// checkMemoryAccess check memory range [start, start+size) accessed by instruction
+func (l *linter) checkMemoryAccess(ins Instruction, start uint16, size int, write bool) {
+	if size == 0 {
+		return
+	}
+	end := int(start) + size - 1
+	name := InstructionTypeNames[ins.Type()]
+	if end >= Chip8RAMSize {
+		l.report(ins.Address(), SEVERITY_ERROR, "memory-range", "%s accesses 0x%03X-0x%03X past 0xFFF", name, start, end)
+		return
+	}
+	if !write {
+		return
+	}
+	if int(start) < Chip8ProgStartAddr {
+		l.report(ins.Address(), SEVERITY_ERROR, "write-font", "%s writes into font/interpreter area 0x%03X-0x%03X",
+			name, start, end)
+		return
+	}
+	for addr := int(start); addr <= end; addr++ {
+		if l.code[uint16(addr)] {
+			l.report(ins.Address(), SEVERITY_WARNING, "write-code", "%s writes into program code at 0x%03X", name, addr)
+			return
+		}
+	}
+}
+
+// transfer apply instruction to state. findings are reported if check is true
+func (l *linter) transfer(ins Instruction, state *lintState, check bool) {
+	reads, writes := registerUsage(ins)
+	if check && reads&^state.init != 0 {
+		l.report(ins.Address(), SEVERITY_WARNING, "uninit-register",
+			"%s read before written (registers are zero only by interpreter convention)", l.registerNames(reads&^state.init))
+	}
+	state.init |= writes
+
+	op := ins.Type()
+	switch op {
+	case OP_DXYN, OP_FX33, OP_FX55, OP_FX65, OP_FX1E:
+		if check && state.iState == I_UNSET {
+			l.report(ins.Address(), SEVERITY_WARNING, "uninit-register", "I is used before set")
+		}
+	}
+	if check && state.iState == I_KNOWN {
+		switch ins := ins.(type) {
+		case TwoRegConstIns:
+			l.checkMemoryAccess(ins, state.i, int(ins.num), false)
+		case OneRegIns:
+			switch op {
+			case OP_FX33:
+				l.checkMemoryAccess(ins, state.i, 3, true)
+			case OP_FX55:
+				l.checkMemoryAccess(ins, state.i, int(ins.reg)+1, true)
+			case OP_FX65:
+				l.checkMemoryAccess(ins, state.i, int(ins.reg)+1, false)
+			}
+		}
+	}
+
+	switch op {
+	case OP_ANNN:
+		state.iState, state.i = I_KNOWN, ins.(AddrIns).target
+	case OP_FX1E, OP_FX29:
+		state.iState = I_UNKNOWN
+	case OP_2NNN:
+		callee := ins.(AddrIns).target
+		state.init |= l.mayWrite[callee]
+		if l.mayWriteI[callee] {
+			state.iState = I_UNKNOWN
+		}
+	}
+}
+
+// analyzeSubroutine run forward dataflow analysis of I and initialized registers, then report findings
+func (l *linter) analyzeSubroutine(sub *Subroutine) {
+	blocks := make(map[uint16]*BasicBlock)
+	for _, b := range sub.blocks {
+		blocks[b.start] = b
+	}
+	in := make(map[uint16]lintState)
+	entry := lintState{reached: true}
+	if sub.entry != Chip8ProgStartAddr { // state of caller is unknown
+		entry = lintState{reached: true, iState: I_UNKNOWN, init: 0xFFFF}
+	}
+	in[sub.entry] = entry
+	workList := []uint16{sub.entry}
+	for len(workList) > 0 {
+		start := workList[0]
+		workList = workList[1:]
+		state := in[start]
+		for _, ins := range blocks[start].instructions {
+			l.transfer(ins, &state, false)
+		}
+		for _, succ := range blocks[start].succs {
+			if _, ok := blocks[succ.target]; !ok {
+				continue
+			}
+			merged := in[succ.target].merge(state)
+			if merged != in[succ.target] {
+				in[succ.target] = merged
+				workList = append(workList, succ.target)
+			}
+		}
+	}
+	for _, b := range sub.blocks {
+		state, ok := in[b.start]
+		if !ok {
+			continue
+		}
+		for _, ins := range b.instructions {
+			l.transfer(ins, &state, true)
+		}
+	}
+}
+
+func (l *linter) checkUnreachable() {
+	end := Chip8ProgStartAddr + len(l.buf)
+	for addr := Chip8ProgStartAddr; addr < end; {
+		if l.code[uint16(addr)] || l.annotation.isData(uint16(addr)) {
+			addr++
+			continue
+		}
+		start := addr
+		for addr < end && !l.code[uint16(addr)] && !l.annotation.isData(uint16(addr)) {
+			addr++
+		}
+		l.report(uint16(start), SEVERITY_INFO, "unreachable",
+			"0x%03X-0x%03X (%d bytes) is unreachable (mark as data by annotation if it is)", start, addr-1, addr-start)
+	}
+}
+
+// Lint analyze program statically and report likely bugs sorted by address
+func Lint(buf []byte, annotation *Annotation) LintFindings {
+	l := &linter{
+		buf:        buf,
+		annotation: annotation,
+		cfg:        BuildCFG(buf, annotation),
+		code:       make(map[uint16]bool),
+		subs:       make(map[uint16]*Subroutine),
+		mayWrite:   make(map[uint16]uint16),
+		mayWriteI:  make(map[uint16]bool),
+		reported:   make(map[string]bool),
+	}
+	for _, sub := range l.cfg.subroutines {
+		l.subs[sub.entry] = sub
+		l.forEachInstruction(sub, func(ins Instruction) {
+			l.code[ins.Address()] = true
+			l.code[ins.Address()+1] = true
+		})
+	}
+	if _, ok := l.subs[Chip8ProgStartAddr]; !ok { // empty program
+		return nil
+	}
+
+	l.computeMayWrite()
+	l.checkCallDepth()
+	l.checkReturnWithoutCall()
+	l.checkJumpTargets()
+	for _, sub := range l.cfg.subroutines {
+		l.analyzeSubroutine(sub)
+	}
+	l.checkUnreachable()
+	sort.SliceStable(l.findings, func(i, j int) bool { return l.findings[i].Address < l.findings[j].Address })
+	return l.findings
+}
@@ -0,0 +1,581 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// LSP message types. only fields used by the server are declared
+
+type lspRequest struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+}
+
+type lspResponse struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Result  any              `json:"result"`
+}
+
+type lspErrorResponse struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Error   lspError         `json:"error"`
+}
+
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type lspNotification struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  any    `json:"params"`
+}
+
+type lspPosition struct {
+	Line      int `json:"line"`      // 0-based
+	Character int `json:"character"` // 0-based
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type lspTextDocumentPositionParams struct {
+	TextDocument struct {
+		URI string `json:"uri"`
+	} `json:"textDocument"`
+	Position lspPosition `json:"position"`
+	Context  struct {
+		IncludeDeclaration bool `json:"includeDeclaration"`
+	} `json:"context"`
+}
+
+type lspCompletionItem struct {
+	Label  string `json:"label"`
+	Kind   int    `json:"kind"`
+	Detail string `json:"detail,omitempty"`
+}
+
+const (
+	LSP_ERROR_METHOD_NOT_FOUND = -32601
+	LSP_ERROR_INVALID_PARAMS   = -32602
+
+	lspSeverityError   = 1
+	lspSeverityWarning = 2
+
+	lspCompletionVariable  = 6
+	lspCompletionKeyword   = 14
+	lspCompletionReference = 18
+	lspCompletionConstant  = 21
+)
+
+// lspSymbol is definition or reference of label/constant in source
+type lspSymbol struct {
+	name  string // qualified name
+	file  string
+	line  int // 1-based
+	col   int // 1-based
+	len   int
+	isDef bool
+	desc  string // detail for hover and completion
+}
+
+func (s *lspSymbol) contains(file string, pos lspPosition) bool {
+	return s.file == file && s.line == pos.Line+1 && s.col-1 <= pos.Character && pos.Character <= s.col-1+s.len
+}
+
+func (s *lspSymbol) location() lspLocation {
+	start := lspPosition{Line: s.line - 1, Character: s.col - 1}
+	end := lspPosition{Line: s.line - 1, Character: s.col - 1 + s.len}
+	return lspLocation{URI: pathToURI(s.file), Range: lspRange{Start: start, End: end}}
+}
+
+// lspAnalysis is result of analyzing a document
+type lspAnalysis struct {
+	diagnostics Diagnostics
+	symbols     []*lspSymbol
+	octo        bool
+}
+
+type LanguageServer struct {
+	reader    *bufio.Reader
+	writer    io.Writer
+	documents map[string]string // path to text
+}
+
+func NewLanguageServer(reader io.Reader, writer io.Writer) *LanguageServer {
+	return &LanguageServer{reader: bufio.NewReader(reader), writer: writer, documents: make(map[string]string)}
+}
+
+func uriToPath(uri string) string {
+	if u, err := url.Parse(uri); err == nil && u.Scheme == "file" {
+		return filepath.FromSlash(u.Path)
+	}
+	return uri
+}
+
+func pathToURI(path string) string {
+	if strings.Contains(path, "://") {
+		return path
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(abs)}).String()
+}
+
+// readMessage read a message framed by Content-Length header
+func (s *LanguageServer) readMessage() ([]byte, error) {
+	length := -1
+	for {
+		line, err := s.reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if name, value, ok := strings.Cut(line, ":"); ok && strings.EqualFold(name, "Content-Length") {
+			if length, err = strconv.Atoi(strings.TrimSpace(value)); err != nil {
+				return nil, fmt.Errorf("invalid Content-Length: %s", value)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("missing Content-Length header")
+	}
+	buf := make([]byte, length)
+	_, err := io.ReadFull(s.reader, buf)
+	return buf, err
+}
+
+func (s *LanguageServer) writeMessage(message any) error {
+	buf, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(s.writer, "Content-Length: %d\r\n\r\n%s", len(buf), buf)
+	return err
+}
+
+// Serve process messages until exit notification or end of input
+func (s *LanguageServer) Serve() error {
+	for {
+		buf, err := s.readMessage()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var request lspRequest
+		if err := json.Unmarshal(buf, &request); err != nil {
+			return fmt.Errorf("broken message: %v", err)
+		}
+		if request.Method == "exit" {
+			return nil
+		}
+		result, lspErr := s.dispatch(&request)
+		if request.ID == nil { // notification
+			continue
+		}
+		if lspErr != nil {
+			err = s.writeMessage(lspErrorResponse{JSONRPC: "2.0", ID: request.ID, Error: *lspErr})
+		} else {
+			err = s.writeMessage(lspResponse{JSONRPC: "2.0", ID: request.ID, Result: result})
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *LanguageServer) dispatch(request *lspRequest) (any, *lspError) {
+	var params struct {
+		lspTextDocumentPositionParams
+		TextDocument struct {
+			URI  string `json:"uri"`
+			Text string `json:"text"`
+		} `json:"textDocument"`
+		ContentChanges []struct {
+			Text string `json:"text"`
+		} `json:"contentChanges"`
+	}
+	if len(request.Params) > 0 {
+		if err := json.Unmarshal(request.Params, &params); err != nil {
+			return nil, &lspError{Code: LSP_ERROR_INVALID_PARAMS, Message: err.Error()}
+		}
+	}
+	path := uriToPath(params.TextDocument.URI)
+
+	switch request.Method {
+	case "initialize":
+		return map[string]any{
+			"capabilities": map[string]any{
+				"textDocumentSync":   1, // full
+				"hoverProvider":      true,
+				"definitionProvider": true,
+				"referencesProvider": true,
+				"completionProvider": map[string]any{"triggerCharacters": []string{".", " "}},
+			},
+			"serverInfo": map[string]string{"name": "octochip"},
+		}, nil
+	case "shutdown", "initialized", "textDocument/didSave":
+		return nil, nil
+	case "textDocument/didOpen":
+		s.documents[path] = params.TextDocument.Text
+		s.publishDiagnostics(path)
+		return nil, nil
+	case "textDocument/didChange":
+		if n := len(params.ContentChanges); n > 0 {
+			s.documents[path] = params.ContentChanges[n-1].Text
+		}
+		s.publishDiagnostics(path)
+		return nil, nil
+	case "textDocument/didClose":
+		delete(s.documents, path)
+		_ = s.writeMessage(lspNotification{JSONRPC: "2.0", Method: "textDocument/publishDiagnostics",
+			Params: map[string]any{"uri": params.TextDocument.URI, "diagnostics": []lspDiagnostic{}}})
+		return nil, nil
+	case "textDocument/hover":
+		return s.hover(path, params.Position), nil
+	case "textDocument/definition":
+		return s.definition(path, params.Position), nil
+	case "textDocument/references":
+		return s.references(path, params.Position, params.Context.IncludeDeclaration), nil
+	case "textDocument/completion":
+		return s.completion(path, params.Position), nil
+	}
+	if strings.HasPrefix(request.Method, "$/") {
+		return nil, nil
+	}
+	return nil, &lspError{Code: LSP_ERROR_METHOD_NOT_FOUND, Message: "method not found: " + request.Method}
+}
+
+func isOctoSource(path string) bool {
+	return filepath.Ext(path) == ".8o"
+}
+
+func (s *LanguageServer) analyze(path string) *lspAnalysis {
+	text := s.documents[path]
+	if isOctoSource(path) {
+		return analyzeOcto(path, text)
+	}
+	return analyzeAsm(path, text)
+}
+
+var lspSymbolPattern = regexp.MustCompile(`\.?[A-Za-z_][A-Za-z0-9_]*`)
+
+// analyzeAsm assemble document and collect diagnostics and symbols
+func analyzeAsm(path string, text string) *lspAnalysis {
+	assembler := NewAssembler()
+	if err := assembler.Parse(path, strings.NewReader(text), filepath.Dir(path)); err != nil {
+		assembler.report(err)
+	}
+	_ = assembler.Link()
+	_ = assembler.Encode(io.Discard) // reports remaining errors even if link failed
+	analysis := &lspAnalysis{diagnostics: assembler.Diagnostics()}
+
+	for _, stmt := range assembler.statements {
+		for i, label := range stmt.labels {
+			symbol := &lspSymbol{name: label, file: stmt.file, line: stmt.line, col: stmt.labelCols[i], isDef: true}
+			symbol.len = len(label)
+			if dot := strings.IndexByte(label, '.'); dot > 0 {
+				symbol.len -= dot
+			}
+			if addr, ok := assembler.labels[label]; ok {
+				symbol.desc = fmt.Sprintf("label `%s` = 0x%03X", label, addr)
+			} else {
+				symbol.desc = fmt.Sprintf("label `%s`", label)
+			}
+			analysis.symbols = append(analysis.symbols, symbol)
+		}
+		operands := stmt.operands
+		switch stmt.mnemonic {
+		case "INCLUDE", "INCBIN":
+			continue
+		case "SECTION":
+			operands = operands[min(1, len(operands)):]
+		case "EQU":
+			if len(operands) > 0 {
+				name := operands[0]
+				analysis.symbols = append(analysis.symbols, &lspSymbol{name: name.expr, file: stmt.file,
+					line: stmt.line, col: name.col, len: len(name.expr), isDef: true,
+					desc: fmt.Sprintf("constant `%s` EQU %s", name.expr, strings.Join(operandTexts(operands[1:]), ", "))})
+				operands = operands[1:]
+			}
+		}
+		for _, operand := range operands {
+			if operand.kind != OPERAND_ADDR {
+				continue
+			}
+			for _, m := range lspSymbolPattern.FindAllStringIndex(operand.expr, -1) {
+				if m[0] > 0 && isIdentChar(operand.expr[m[0]-1]) { // part of number such as 0xFF
+					continue
+				}
+				name := operand.expr[m[0]:m[1]]
+				analysis.symbols = append(analysis.symbols, &lspSymbol{name: qualifyLabel(stmt.scope, name),
+					file: stmt.file, line: stmt.line, col: operand.col + m[0], len: len(name)})
+			}
+		}
+	}
+	return analysis
+}
+
+func operandTexts(operands []asmOperand) []string {
+	texts := make([]string, len(operands))
+	for i, operand := range operands {
+		texts[i] = operand.expr
+	}
+	return texts
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c == '@' || ('0' <= c && c <= '9') || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+var octoKeywords = []string{"i", "delay", "buzzer", "key", "random", "clear", "return", "jump", "jump0",
+	"sprite", "bcd", "save", "load", "if", "then", "begin", "else", "end", "loop", "again", "while", "hex",
+	":", ":const", ":alias", ":macro", ":calc", ":org", ":byte", ":call"}
+
+var octoDefinitionKeywords = map[string]bool{":": true, ":const": true, ":calc": true, ":alias": true, ":macro": true}
+
+var octoErrorPattern = regexp.MustCompile(`^line (\d+): (.*)$`)
+
+// analyzeOcto compile Octo document and collect diagnostics and symbols
+func analyzeOcto(path string, text string) *lspAnalysis {
+	analysis := &lspAnalysis{octo: true}
+	if err := CompileOcto(strings.NewReader(text), io.Discard); err != nil {
+		diagnostic := &Diagnostic{File: path, Severity: SEVERITY_ERROR, Message: err.Error()}
+		if m := octoErrorPattern.FindStringSubmatch(err.Error()); m != nil {
+			diagnostic.Line, _ = strconv.Atoi(m[1])
+			diagnostic.Message = m[2]
+		}
+		analysis.diagnostics = append(analysis.diagnostics, diagnostic)
+	}
+
+	tokens, _ := tokenizeOcto(strings.NewReader(text))
+	defined := make(map[string]bool)
+	for i, token := range tokens {
+		if octoDefinitionKeywords[token.text] {
+			if i+1 < len(tokens) {
+				name := tokens[i+1]
+				defined[name.text] = true
+				analysis.symbols = append(analysis.symbols, &lspSymbol{name: name.text, file: path, line: name.line,
+					col: name.col, len: len(name.text), isDef: true, desc: fmt.Sprintf("%s %s", token.text, name.text)})
+			}
+		}
+	}
+	for i, token := range tokens {
+		if !defined[token.text] || slices.Contains(octoKeywords, token.text) ||
+			(i > 0 && octoDefinitionKeywords[tokens[i-1].text]) {
+			continue
+		}
+		analysis.symbols = append(analysis.symbols, &lspSymbol{name: token.text, file: path, line: token.line,
+			col: token.col, len: len(token.text)})
+	}
+	return analysis
+}
+
+func (s *LanguageServer) publishDiagnostics(path string) {
+	analysis := s.analyze(path)
+	diagnostics := make([]lspDiagnostic, 0, len(analysis.diagnostics))
+	for _, d := range analysis.diagnostics {
+		if d.File != path && d.File != "" {
+			continue
+		}
+		line := max(d.Line-1, 0)
+		r := lspRange{Start: lspPosition{Line: line}, End: lspPosition{Line: line + 1}} // whole line if column is unknown
+		if d.Column > 0 {
+			r = lspRange{Start: lspPosition{Line: line, Character: d.Column - 1},
+				End: lspPosition{Line: line, Character: d.Column - 1 + max(d.Length, 1)}}
+		}
+		severity := lspSeverityError
+		if d.Severity == SEVERITY_WARNING {
+			severity = lspSeverityWarning
+		}
+		diagnostics = append(diagnostics, lspDiagnostic{Range: r, Severity: severity, Source: "octochip", Message: d.Message})
+	}
+	_ = s.writeMessage(lspNotification{JSONRPC: "2.0", Method: "textDocument/publishDiagnostics",
+		Params: map[string]any{"uri": pathToURI(path), "diagnostics": diagnostics}})
+}
+
+// symbolAt returns symbol at the position and whole symbols
+func (s *LanguageServer) symbolAt(path string, pos lspPosition) (*lspSymbol, *lspAnalysis) {
+	analysis := s.analyze(path)
+	for _, symbol := range analysis.symbols {
+		if symbol.contains(path, pos) {
+			return symbol, analysis
+		}
+	}
+	return nil, analysis
+}
+
+func (s *LanguageServer) definition(path string, pos lspPosition) []lspLocation {
+	symbol, analysis := s.symbolAt(path, pos)
+	locations := []lspLocation{}
+	if symbol == nil {
+		return locations
+	}
+	for _, def := range analysis.symbols {
+		if def.isDef && def.name == symbol.name {
+			locations = append(locations, def.location())
+		}
+	}
+	return locations
+}
+
+func (s *LanguageServer) references(path string, pos lspPosition, includeDeclaration bool) []lspLocation {
+	symbol, analysis := s.symbolAt(path, pos)
+	locations := []lspLocation{}
+	if symbol == nil {
+		return locations
+	}
+	for _, ref := range analysis.symbols {
+		if ref.name == symbol.name && (!ref.isDef || includeDeclaration) {
+			locations = append(locations, ref.location())
+		}
+	}
+	return locations
+}
+
+// wordAt returns word under the position
+func (s *LanguageServer) wordAt(path string, pos lspPosition) string {
+	lines := strings.Split(s.documents[path], "\n")
+	if pos.Line >= len(lines) {
+		return ""
+	}
+	line := lines[pos.Line]
+	start, end := min(pos.Character, len(line)), min(pos.Character, len(line))
+	for start > 0 && (isIdentChar(line[start-1]) || line[start-1] == '.' || line[start-1] == ':') {
+		start--
+	}
+	for end < len(line) && (isIdentChar(line[end]) || line[end] == '.') {
+		end++
+	}
+	return line[start:end]
+}
+
+// mnemonicDoc describes all forms of the mnemonic
+func mnemonicDoc(mnemonic string) string {
+	var sb strings.Builder
+	for op := OP_0NNN; op < OP_INVALID; op++ {
+		if InstructionTypeNames[op] == mnemonic {
+			sb.WriteString(fmt.Sprintf("- `%s` (%s): %s\n", InstructionSyntax(op), InstructionEncoding(op),
+				InstructionDescriptions[op]))
+		}
+	}
+	if sb.Len() == 0 {
+		return ""
+	}
+	return fmt.Sprintf("**%s**\n\n%s", mnemonic, sb.String())
+}
+
+func (s *LanguageServer) hover(path string, pos lspPosition) any {
+	content := ""
+	if symbol, analysis := s.symbolAt(path, pos); symbol != nil {
+		for _, def := range analysis.symbols {
+			if def.isDef && def.name == symbol.name {
+				content = fmt.Sprintf("%s\n\ndefined at %s:%d", def.desc, filepath.Base(def.file), def.line)
+				break
+			}
+		}
+	} else if !isOctoSource(path) {
+		content = mnemonicDoc(strings.ToUpper(s.wordAt(path, pos)))
+	}
+	if content == "" {
+		return nil
+	}
+	return map[string]any{"contents": map[string]string{"kind": "markdown", "value": content}}
+}
+
+func (s *LanguageServer) completion(path string, pos lspPosition) []lspCompletionItem {
+	analysis := s.analyze(path)
+	var items []lspCompletionItem
+	seen := make(map[string]bool)
+	add := func(item lspCompletionItem) {
+		if !seen[item.Label] {
+			seen[item.Label] = true
+			items = append(items, item)
+		}
+	}
+	for i := 0; i < 16; i++ {
+		name := fmt.Sprintf("V%X", i)
+		if analysis.octo {
+			name = fmt.Sprintf("v%x", i)
+		}
+		add(lspCompletionItem{Label: name, Kind: lspCompletionVariable, Detail: "register"})
+	}
+	if analysis.octo {
+		for _, keyword := range octoKeywords {
+			add(lspCompletionItem{Label: keyword, Kind: lspCompletionKeyword})
+		}
+	} else {
+		for op := OP_0NNN; op < OP_INVALID; op++ {
+			add(lspCompletionItem{Label: InstructionTypeNames[op], Kind: lspCompletionKeyword, Detail: InstructionSyntax(op)})
+		}
+		for _, keyword := range []string{"I", "[I]", "DT", "ST", "K", "F", "B", "DB", "DW", "EQU", "SECTION", "INCLUDE", "INCBIN"} {
+			add(lspCompletionItem{Label: keyword, Kind: lspCompletionKeyword})
+		}
+	}
+
+	scope := ""
+	for _, symbol := range analysis.symbols { // scope of local labels at the position
+		if symbol.isDef && symbol.file == path && symbol.line <= pos.Line+1 && !strings.Contains(symbol.name, ".") {
+			scope = symbol.name
+		}
+	}
+	var labels []lspCompletionItem
+	for _, symbol := range analysis.symbols {
+		if !symbol.isDef {
+			continue
+		}
+		name := symbol.name
+		if dot := strings.IndexByte(name, '.'); dot > 0 {
+			if name[:dot] != scope {
+				continue
+			}
+			name = name[dot:]
+		}
+		kind := lspCompletionReference
+		if strings.HasPrefix(symbol.desc, "constant") || strings.HasPrefix(symbol.desc, ":const") {
+			kind = lspCompletionConstant
+		}
+		labels = append(labels, lspCompletionItem{Label: name, Kind: kind, Detail: symbol.desc})
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].Label < labels[j].Label })
+	for _, item := range labels {
+		add(item)
+	}
+	return items
+}
@@ -4,54 +4,667 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/alecthomas/kong"
+	"io"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 type CLIRun struct {
-	Path string `arg:"positional" required:"" help:"Path to CHIP-8 ROM"`
+	Path       string   `arg:"positional" required:"" help:"Path to CHIP-8 ROM (or Octo source with .8o extension)"`
+	DumpRAM    string   `name:"dump-ram" help:"Write RAM image to the file when VM stops, also on error or crash" type:"path"`
+	Trace      string   `help:"Write executed instructions to the file ('-' for stdout)"`
+	SourceMap  string   `name:"source-map" help:"Source map written by asm --source-map (default: <ROM>.map if exists)" type:"path"`
+	Break      []string `help:"Stop at address or source location (FILE:LINE) and dump state"`
+	Patch      string   `help:"Apply IPS or BPS patch to the ROM before run" type:"path"`
+	Frontend   string   `default:"sdl" help:"Display and input frontend (sdl, term, term:braille, headless, web:ADDR, vnc:[HOST:]PORT)"`
+	Config     string   `help:"Config file (default: <user config dir>/octochip/config.json if exists)" type:"path"`
+	Keymap     string   `help:"Key mapping (qwerty, azerty, numpad or keymap defined in config)"`
+	Theme      string   `help:"Color theme (mono, inverse, amber, green, lcd, octo or theme defined in config)"`
+	Scale      string   `help:"Integer scale factor, or 'fit' to fit the window keeping aspect ratio"`
+	Fullscreen bool     `help:"Start in fullscreen (toggle with F11 or Alt+Enter)"`
+	Fade       *int     `help:"Frames of phosphor decay after pixel is turned off (0 to disable)"`
+	Deflicker  *bool    `negatable:"" help:"Light pixels lit in either of the last two frames to reduce flicker of XOR drawing"`
+	Upscale    string   `help:"Pixel-art upscaler (none, scale2x, epx, scale3x, scale4x, hq2x)"`
+	Effects    []string `help:"Comma separated post-processing effects (scanlines, grid, lcd)"`
+
+	ScreenshotAt *ScreenshotAt `name:"screenshot-at-frame" placeholder:"N OUT.png" help:"Write PNG screenshot of the N-th frame (60 fps). headless frontend stops after it"`
+	Record       string        `help:"Record frames at 60 fps to GIF, APNG (.png, .apng) or raw Y4M (toggle with F10)" type:"path"`
+	RecordAudio  string        `name:"record-audio" help:"Record sound timer as WAV in sync with --record" type:"path"`
+}
+
+type CLIServe struct {
+	Path      string   `arg:"positional" required:"" help:"Path to CHIP-8 ROM (or Octo source with .8o extension)"`
+	Listen    string   `default:"127.0.0.1:8080" help:"Address to listen HTTP and WebSocket"`
+	Patch     string   `help:"Apply IPS or BPS patch to the ROM before run" type:"path"`
+	Config    string   `help:"Config file (default: <user config dir>/octochip/config.json if exists)" type:"path"`
+	Keymap    string   `help:"Key mapping (qwerty, azerty, numpad or keymap defined in config)"`
+	Theme     string   `help:"Color theme (mono, inverse, amber, green, lcd, octo or theme defined in config)"`
+	Fade      *int     `help:"Frames of phosphor decay after pixel is turned off (0 to disable)"`
+	Deflicker *bool    `negatable:"" help:"Light pixels lit in either of the last two frames to reduce flicker of XOR drawing"`
+	Break     []string `help:"Stop at address or source location (FILE:LINE) and dump state"`
 }
 
 type CLIDisasm struct {
-	Path string `arg:"positional" required:"" help:"Path to CHIP-8 ROM"`
+	Path      string `arg:"positional" required:"" help:"Path to CHIP-8 ROM"`
+	Cfg       string `help:"Write control-flow graph in DOT format to the file" type:"path"`
+	CallGraph bool   `help:"Write whole-program call graph instead of per-subroutine graphs (with --cfg)"`
+	Addr      bool   `help:"Print address and raw opcode word of each instruction"`
+	Start     string `help:"Address or label of the first printed instruction"`
+	End       string `help:"Address or label of the last printed instruction"`
+	Label     string `help:"Only print the region of the label (subroutine body or until the next label)"`
+	Annotate  string `help:"Annotation file of names, comments and code/data regions (default: <ROM>.ann if exists)" type:"path"`
+	Image     string `help:"Disassemble RAM image (dumped by run --dump-ram) and annotate bytes differ from the ROM" type:"path"`
+}
+
+type CLIDecompile struct {
+	Path     string `arg:"positional" required:"" help:"Path to CHIP-8 ROM"`
+	Annotate string `help:"Annotation file of names, comments and code/data regions (default: <ROM>.ann if exists)" type:"path"`
+}
+
+type CLIAsm struct {
+	Paths      []string `arg:"positional" required:"" help:"Paths to assembly sources" type:"path"`
+	Output     string   `short:"o" help:"Path to output CHIP-8 ROM (default: first source path with .ch8 extension)" type:"path"`
+	Map        bool     `help:"Print section layout and program size"`
+	Listing    string   `help:"Write listing of address, bytes and source line to the file" type:"path"`
+	SourceMap  string   `name:"source-map" help:"Write source map (JSON) from ROM address to source line to the file" type:"path"`
+	DiagFormat string   `name:"diag-format" enum:"text,json" default:"text" help:"Format of diagnostics (text, json). json is written to stdout"`
+}
+
+type CLIPatch struct {
+	Path   string `arg:"positional" required:"" help:"Path to CHIP-8 ROM"`
+	Patch  string `arg:"positional" required:"" help:"Path to IPS or BPS patch" type:"path"`
+	Output string `short:"o" help:"Path to patched ROM (default: ROM path with .patched.ch8 extension)" type:"path"`
+}
+
+type CLIMakePatch struct {
+	Original string `arg:"positional" required:"" help:"Path to original CHIP-8 ROM" type:"path"`
+	Modified string `arg:"positional" required:"" help:"Path to modified CHIP-8 ROM" type:"path"`
+	Output   string `short:"o" required:"" help:"Path to output patch" type:"path"`
+	Format   string `enum:",ips,bps" default:"" help:"Patch format (ips, bps). default is guessed from output extension"`
+}
+
+type CLIDiff struct {
+	PathA   string `arg:"positional" required:"" help:"Path to old CHIP-8 ROM"`
+	PathB   string `arg:"positional" required:"" help:"Path to new CHIP-8 ROM"`
+	Context int    `short:"U" default:"3" help:"Number of context instructions around differences"`
+}
+
+type CLILsp struct{}
+
+type CLILint struct {
+	Path     string `arg:"positional" required:"" help:"Path to CHIP-8 ROM"`
+	Annotate string `help:"Annotation file of names, comments and code/data regions (default: <ROM>.ann if exists)" type:"path"`
+	Format   string `enum:"text,json" default:"text" help:"Output format (text, json)"`
+}
+
+type CLIOptimize struct {
+	Paths    []string `arg:"positional" required:"" help:"Path to CHIP-8 ROM (or Octo source with .8o extension), or paths to assembly sources (.s, .asm)" type:"path"`
+	Output   string   `short:"o" help:"Path to optimized CHIP-8 ROM (default: first path with .opt.ch8 extension)" type:"path"`
+	Annotate string   `help:"Annotation file of names, comments and code/data regions of ROM (default: <ROM>.ann if exists)" type:"path"`
+}
+
+type CLIKeys struct {
+	Path   string `arg:"positional" optional:"" help:"Path to CHIP-8 ROM to show its per-ROM mapping"`
+	Config string `help:"Config file (default: <user config dir>/octochip/config.json if exists)" type:"path"`
+	Keymap string `help:"Key mapping (qwerty, azerty, numpad or keymap defined in config)"`
+}
+
+type CLICompile struct {
+	Path   string `arg:"positional" required:"" help:"Path to Octo source"`
+	Output string `short:"o" help:"Path to output CHIP-8 ROM (default: source path with .ch8 extension)" type:"path"`
 }
 
 var CLI struct {
 	Run CLIRun `cmd:"" help:"Run CHIP-8 ROM"`
 
+	Serve CLIServe `cmd:"" help:"Run CHIP-8 ROM and serve it to browsers over HTTP and WebSocket"`
+
 	Disasm CLIDisasm `cmd:"" help:"Disassemble CHIP-8 ROM"`
+
+	Decompile CLIDecompile `cmd:"" help:"Decompile CHIP-8 ROM into pseudocode"`
+
+	Asm CLIAsm `cmd:"" help:"Assemble source into CHIP-8 ROM"`
+
+	Compile CLICompile `cmd:"" help:"Compile Octo source into CHIP-8 ROM"`
+
+	Diff CLIDiff `cmd:"" help:"Compare two CHIP-8 ROMs instruction by instruction"`
+
+	Lsp CLILsp `cmd:"" help:"Run language server for assembly and Octo source over stdio"`
+
+	Lint CLILint `cmd:"" help:"Report likely bugs of CHIP-8 ROM without running it"`
+
+	Patch CLIPatch `cmd:"" help:"Apply IPS or BPS patch to CHIP-8 ROM"`
+
+	MakePatch CLIMakePatch `cmd:"" name:"makepatch" help:"Create IPS or BPS patch from original and modified ROMs"`
+
+	Optimize CLIOptimize `cmd:"" help:"Shrink CHIP-8 ROM or assembly program by peephole optimizations"`
+
+	Keys CLIKeys `cmd:"" help:"Print active key mapping as the keypad grid"`
+}
+
+// Run is the same as run command with web frontend
+func (s *CLIServe) Run() error {
+	run := &CLIRun{
+		Path:      s.Path,
+		Patch:     s.Patch,
+		Break:     s.Break,
+		Frontend:  "web:" + s.Listen,
+		Config:    s.Config,
+		Keymap:    s.Keymap,
+		Theme:     s.Theme,
+		Fade:      s.Fade,
+		Deflicker: s.Deflicker,
+	}
+	return run.Run()
 }
 
 func (r *CLIRun) Run() error {
-	device := SDLDevice{}
-	err := device.Setup()
+	flags := &Settings{Keymap: r.Keymap, Theme: r.Theme, Scale: ScaleMode(r.Scale), Fade: r.Fade, Deflicker: r.Deflicker,
+		Upscale: r.Upscale, Effects: r.Effects}
+	if r.Fullscreen {
+		flags.Fullscreen = &r.Fullscreen
+	}
+	options, err := loadFrontendOptions(r.Config, r.Path, flags)
 	if err != nil {
+		return fmt.Errorf("run error: %v\n", err)
+	}
+	device, err := NewFrontend(r.Frontend, options)
+	if err != nil {
+		return fmt.Errorf("run error: %v\n", err)
+	}
+	if r.RecordAudio != "" && r.Record == "" {
+		return fmt.Errorf("run error: --record-audio requires --record\n")
+	}
+	var capture *FrameCapture
+	if r.ScreenshotAt != nil || r.Record != "" {
+		capture = NewFrameCapture(device, options.Display)
+		if r.ScreenshotAt != nil {
+			capture.screenshot = r.ScreenshotAt
+			_, capture.quitAfter = capture.Frontend.(*HeadlessDevice)
+		}
+		device = capture
+	}
+	if err = device.Setup(); err != nil {
 		return fmt.Errorf("device setup error: %v\n", err)
 	}
 	defer device.Teardown()
 
-	buf, err := os.ReadFile(r.Path)
+	buf, err := readROM(r.Path)
 	if err != nil {
 		return fmt.Errorf("run error: %v\n", err)
 	}
+	if r.Patch != "" {
+		if buf, err = applyPatchFile(buf, r.Patch); err != nil {
+			return fmt.Errorf("run error: %v\n", err)
+		}
+	}
 	reader := bytes.NewReader(buf)
-	vm, err := NewChip8VM(reader, &device)
+	vm, err := NewChip8VM(reader, device)
 	if err != nil {
 		return fmt.Errorf("run error: %v\n", err)
 	}
+	if capture != nil && r.Record != "" { // files are created after frontend and ROM are ready
+		capture.sound = func() bool { return vm.st > 0 }
+		if err = capture.startRecording(r.Record, r.RecordAudio); err != nil {
+			return fmt.Errorf("run error: %v\n", err)
+		}
+	}
 	vm.Dump(os.Stdout)
-	if err = vm.Run(); err != nil {
+	tracer, closeTrace, err := r.setupTracer()
+	if err != nil {
 		return fmt.Errorf("run error: %v\n", err)
 	}
+	defer closeTrace()
+	vm.SetHook(tracer.Hook)
+	err = r.runVM(vm)
+	device.Teardown() // restore terminal before printing
+	if err != nil {
+		return fmt.Errorf("run error: %v\n", err)
+	}
+	if tracer.Stopped() {
+		fmt.Printf("stopped at breakpoint %s\n", tracer.Location(vm.pc))
+		vm.Dump(os.Stdout)
+	}
 	return nil
 }
 
+// runVM run VM and write RAM image when it stops, even if it fails or crashes by invalid instruction
+func (r *CLIRun) runVM(vm *Chip8VM) (err error) {
+	defer func() {
+		if r.DumpRAM == "" {
+			return
+		}
+		if dumpErr := r.dumpRAM(vm); dumpErr != nil && err == nil {
+			err = dumpErr
+		}
+	}()
+	return vm.Run()
+}
+
+func (r *CLIRun) setupTracer() (*Tracer, func(), error) {
+	sourceMap, err := loadSourceMap(r.SourceMap, r.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var writer io.Writer
+	closeTrace := func() {}
+	switch r.Trace {
+	case "":
+	case "-":
+		writer = os.Stdout
+	default:
+		file, err := os.Create(r.Trace)
+		if err != nil {
+			return nil, nil, err
+		}
+		writer = file
+		closeTrace = func() { _ = file.Close() }
+	}
+	tracer, err := NewTracer(writer, sourceMap, r.Break)
+	if err != nil {
+		closeTrace()
+		return nil, nil, err
+	}
+	return tracer, closeTrace, nil
+}
+
+func (r *CLIRun) dumpRAM(vm *Chip8VM) error {
+	file, err := os.Create(r.DumpRAM)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return vm.DumpMemory(file)
+}
+
 func (d *CLIDisasm) Run() error {
 	buf, err := os.ReadFile(d.Path)
 	if err != nil {
 		return fmt.Errorf("disasm error: %v\n", err)
 	}
+	annotation, err := loadAnnotation(d.Annotate, d.Path)
+	if err != nil {
+		return fmt.Errorf("disasm error: %v\n", err)
+	}
+	if d.Cfg != "" {
+		return d.writeCFG(buf, annotation)
+	}
+	option := DisasmOption{Annotation: annotation, ShowAddr: d.Addr, Start: d.Start, End: d.End, Label: d.Label}
+	if d.Image != "" {
+		image, err := os.ReadFile(d.Image)
+		if err != nil {
+			return fmt.Errorf("disasm error: %v\n", err)
+		}
+		option.Original = buf
+		if buf, err = ProgramFromMemoryImage(image, buf); err != nil {
+			return fmt.Errorf("disasm error: %v\n", err)
+		}
+	}
 	reader := bytes.NewReader(buf)
-	err = Disassemble(reader, os.Stdout)
+	err = Disassemble(reader, os.Stdout, option)
+	if err != nil {
+		return fmt.Errorf("disasm error: %v\n", err)
+	}
+	return nil
+}
+
+func (d *CLIDecompile) Run() error {
+	buf, err := os.ReadFile(d.Path)
+	if err != nil {
+		return fmt.Errorf("decompile error: %v\n", err)
+	}
+	annotation, err := loadAnnotation(d.Annotate, d.Path)
+	if err != nil {
+		return fmt.Errorf("decompile error: %v\n", err)
+	}
+	err = Decompile(bytes.NewReader(buf), os.Stdout, annotation)
+	if err != nil {
+		return fmt.Errorf("decompile error: %v\n", err)
+	}
+	return nil
+}
+
+func (d *CLIDiff) Run() error {
+	bufA, err := os.ReadFile(d.PathA)
+	if err != nil {
+		return fmt.Errorf("diff error: %v\n", err)
+	}
+	bufB, err := os.ReadFile(d.PathB)
+	if err != nil {
+		return fmt.Errorf("diff error: %v\n", err)
+	}
+	annotationA, err := loadAnnotation("", d.PathA)
+	if err != nil {
+		return fmt.Errorf("diff error: %v\n", err)
+	}
+	annotationB, err := loadAnnotation("", d.PathB)
+	if err != nil {
+		return fmt.Errorf("diff error: %v\n", err)
+	}
+	diff := DiffInstructions(bufA, bufB, annotationA, annotationB)
+	if err := diff.Write(os.Stdout, d.PathA, d.PathB, d.Context); err != nil {
+		return fmt.Errorf("diff error: %v\n", err)
+	}
+	return nil
+}
+
+func (l *CLILint) Run() error {
+	buf, err := os.ReadFile(l.Path)
+	if err != nil {
+		return fmt.Errorf("lint error: %v\n", err)
+	}
+	annotation, err := loadAnnotation(l.Annotate, l.Path)
+	if err != nil {
+		return fmt.Errorf("lint error: %v\n", err)
+	}
+	findings := Lint(buf, annotation)
+	if l.Format == "json" {
+		err = findings.WriteJSON(os.Stdout)
+	} else {
+		err = findings.WriteText(os.Stdout)
+	}
+	if err != nil {
+		return fmt.Errorf("lint error: %v\n", err)
+	}
+	if n := findings.Count(SEVERITY_ERROR); n > 0 {
+		return fmt.Errorf("lint error: %d error(s) found\n", n)
+	}
+	return nil
+}
+
+func (l *CLILsp) Run() error {
+	if err := NewLanguageServer(os.Stdin, os.Stdout).Serve(); err != nil {
+		return fmt.Errorf("lsp error: %v\n", err)
+	}
+	return nil
+}
+
+func (a *CLIAsm) Run() error {
+	assembler := NewAssembler()
+	err := a.assemble(assembler)
+	diagnostics := assembler.Diagnostics()
+	if a.DiagFormat == "json" {
+		if err := diagnostics.WriteJSON(os.Stdout); err != nil {
+			return fmt.Errorf("asm error: %v\n", err)
+		}
+	} else {
+		diagnostics.WriteText(os.Stderr)
+	}
+	if diagnostics.HasError() {
+		return fmt.Errorf("asm error: assembly failed\n")
+	}
+	if err != nil {
+		return fmt.Errorf("asm error: %v\n", err)
+	}
+	return nil
+}
+
+func (a *CLIAsm) assemble(assembler *Assembler) error {
+	for _, path := range a.Paths {
+		if err := assembler.ParseFile(path); err != nil {
+			return err
+		}
+	}
+	linkErr := assembler.Link()
+	var rom bytes.Buffer
+	if err := assembler.Encode(&rom); err != nil { // reports remaining errors even if link failed
+		return err
+	}
+	if linkErr != nil {
+		return linkErr
+	}
+	if a.Map && a.DiagFormat != "json" {
+		_ = assembler.WriteLinkMap(os.Stdout)
+	}
+	if a.Listing != "" {
+		if err := writeFile(a.Listing, assembler.WriteListing); err != nil {
+			return err
+		}
+	}
+	if a.SourceMap != "" {
+		if err := writeFile(a.SourceMap, assembler.SourceMap().WriteJSON); err != nil {
+			return err
+		}
+	}
+	output := a.Output
+	if output == "" {
+		output = strings.TrimSuffix(a.Paths[0], filepath.Ext(a.Paths[0])) + ".ch8"
+	}
+	return os.WriteFile(output, rom.Bytes(), 0644)
+}
+
+func writeFile(path string, write func(writer io.Writer) error) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err = write(file); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Run compile the source regardless of its extension
+func (c *CLICompile) Run() error {
+	file, err := os.Open(c.Path)
+	if err != nil {
+		return fmt.Errorf("compile error: %v\n", err)
+	}
+	defer file.Close()
+	var rom bytes.Buffer
+	if err = CompileOcto(file, &rom); err != nil {
+		return fmt.Errorf("compile error: %v\n", err)
+	}
+	output := c.Output
+	if output == "" {
+		output = strings.TrimSuffix(c.Path, filepath.Ext(c.Path)) + ".ch8"
+	}
+	if output == c.Path {
+		return fmt.Errorf("compile error: output overwrites source: %s\n", output)
+	}
+	if err = os.WriteFile(output, rom.Bytes(), 0644); err != nil {
+		return fmt.Errorf("compile error: %v\n", err)
+	}
+	return nil
+}
+
+// applyPatchFile apply IPS or BPS patch file to the ROM
+func applyPatchFile(rom []byte, path string) ([]byte, error) {
+	patch, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ApplyPatch(rom, patch)
+}
+
+func (p *CLIPatch) Run() error {
+	buf, err := os.ReadFile(p.Path)
+	if err != nil {
+		return fmt.Errorf("patch error: %v\n", err)
+	}
+	if buf, err = applyPatchFile(buf, p.Patch); err != nil {
+		return fmt.Errorf("patch error: %v\n", err)
+	}
+	output := p.Output
+	if output == "" {
+		output = strings.TrimSuffix(p.Path, filepath.Ext(p.Path)) + ".patched.ch8"
+	}
+	if err := os.WriteFile(output, buf, 0644); err != nil {
+		return fmt.Errorf("patch error: %v\n", err)
+	}
+	return nil
+}
+
+func (m *CLIMakePatch) Run() error {
+	original, err := os.ReadFile(m.Original)
+	if err != nil {
+		return fmt.Errorf("makepatch error: %v\n", err)
+	}
+	modified, err := os.ReadFile(m.Modified)
+	if err != nil {
+		return fmt.Errorf("makepatch error: %v\n", err)
+	}
+	format := PatchFormat(m.Format)
+	if format == "" {
+		if format, err = PatchFormatFromPath(m.Output); err != nil {
+			return fmt.Errorf("makepatch error: %v (specify --format)\n", err)
+		}
+	}
+	patch, err := MakePatch(format, original, modified)
+	if err != nil {
+		return fmt.Errorf("makepatch error: %v\n", err)
+	}
+	if err := os.WriteFile(m.Output, patch, 0644); err != nil {
+		return fmt.Errorf("makepatch error: %v\n", err)
+	}
+	return nil
+}
+
+func (o *CLIOptimize) Run() error {
+	assembler, err := o.load()
+	if err != nil {
+		return fmt.Errorf("optimize error: %v\n", err)
+	}
+	optimizer := NewOptimizer(assembler)
+	var rom bytes.Buffer
+	err = optimizer.Optimize(&rom)
+	assembler.Diagnostics().WriteText(os.Stderr)
+	if err != nil {
+		return fmt.Errorf("optimize error: optimization failed\n")
+	}
+	output := o.Output
+	if output == "" {
+		output = strings.TrimSuffix(o.Paths[0], filepath.Ext(o.Paths[0])) + ".opt.ch8"
+	}
+	if err := os.WriteFile(output, rom.Bytes(), 0644); err != nil {
+		return fmt.Errorf("optimize error: %v\n", err)
+	}
+	_ = optimizer.WriteReport(os.Stdout)
+	return nil
+}
+
+// load parse assembly sources, or disassemble ROM to optimize it as assembly
+func (o *CLIOptimize) load() (*Assembler, error) {
+	if ext := strings.ToLower(filepath.Ext(o.Paths[0])); ext == ".s" || ext == ".asm" {
+		assembler := NewAssembler()
+		for _, path := range o.Paths {
+			if err := assembler.ParseFile(path); err != nil {
+				return nil, err
+			}
+		}
+		return assembler, nil
+	}
+	if len(o.Paths) > 1 {
+		return nil, fmt.Errorf("only one ROM can be optimized at once")
+	}
+	buf, err := readROM(o.Paths[0])
+	if err != nil {
+		return nil, err
+	}
+	annotation, err := loadAnnotation(o.Annotate, o.Paths[0])
+	if err != nil {
+		return nil, err
+	}
+	return DisassembleForOptimize(o.Paths[0], buf, annotation)
+}
+
+func (k *CLIKeys) Run() error {
+	options, err := loadFrontendOptions(k.Config, k.Path, &Settings{Keymap: k.Keymap})
+	if err != nil {
+		return fmt.Errorf("keys error: %v\n", err)
+	}
+	if err := options.Keymap.WriteGrid(os.Stdout); err != nil {
+		return fmt.Errorf("keys error: %v\n", err)
+	}
+	if err := options.Gamepad.WriteGrid(os.Stdout); err != nil {
+		return fmt.Errorf("keys error: %v\n", err)
+	}
+	return nil
+}
+
+// loadFrontendOptions load config and resolve settings of the ROM. settings given by flags have priority
+func loadFrontendOptions(configPath string, romPath string, flags *Settings) (FrontendOptions, error) {
+	config, err := loadConfig(configPath)
+	if err != nil {
+		return FrontendOptions{}, err
+	}
+	settings := config.ForROM(romPath)
+	settings.merge(flags)
+	var options FrontendOptions
+	if options.Keymap, err = config.NewKeymap(settings); err != nil {
+		return FrontendOptions{}, err
+	}
+	if options.Gamepad, err = config.NewGamepadMap(settings); err != nil {
+		return FrontendOptions{}, err
+	}
+	if options.Display, err = config.NewDisplayOptions(settings); err != nil {
+		return FrontendOptions{}, err
+	}
+	return options, nil
+}
+
+// readROM read CHIP-8 ROM. Octo source (.8o) is compiled
+func readROM(path string) ([]byte, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if filepath.Ext(path) != ".8o" {
+		return buf, nil
+	}
+	var rom bytes.Buffer
+	if err = CompileOcto(bytes.NewReader(buf), &rom); err != nil {
+		return nil, err
+	}
+	return rom.Bytes(), nil
+}
+
+// loadAnnotation load annotation file. if path is empty, load sidecar file of ROM if exists
+func loadAnnotation(path string, romPath string) (*Annotation, error) {
+	if path == "" {
+		path = romPath + ".ann"
+		if _, err := os.Stat(path); err != nil {
+			return nil, nil
+		}
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return LoadAnnotation(file)
+}
+
+// loadSourceMap load source map file. if path is empty, load sidecar file of ROM if exists
+func loadSourceMap(path string, romPath string) (*SourceMap, error) {
+	if path == "" {
+		path = romPath + ".map"
+		if _, err := os.Stat(path); err != nil {
+			return nil, nil
+		}
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return LoadSourceMap(file)
+}
+
+func (d *CLIDisasm) writeCFG(buf []byte, annotation *Annotation) error {
+	file, err := os.Create(d.Cfg)
+	if err != nil {
+		return fmt.Errorf("disasm error: %v\n", err)
+	}
+	defer file.Close()
+	cfg := BuildCFG(buf, annotation)
+	if d.CallGraph {
+		err = cfg.WriteCallGraphDot(file)
+	} else {
+		err = cfg.WriteDot(file)
+	}
 	if err != nil {
 		return fmt.Errorf("disasm error: %v\n", err)
 	}
@@ -0,0 +1,935 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type octoToken struct {
+	text string
+	line int
+	col  int // 1-based column
+}
+
+type octoMacro struct {
+	args []string
+	body []octoToken
+}
+
+type octoFixup struct {
+	addr  int // address of instruction whose NNN is patched
+	label string
+	line  int
+}
+
+type octoControlKind uint8
+
+const (
+	OCTO_BEGIN octoControlKind = iota
+	OCTO_ELSE
+	OCTO_LOOP
+)
+
+type octoControl struct {
+	kind     octoControlKind
+	addr     int   // loop start or address of jump to be patched (begin/else)
+	breakers []int // address of jumps out of loop (while)
+	line     int
+}
+
+type OctoCompiler struct {
+	tokens   []octoToken
+	pos      int
+	rom      [Chip8RAMSize]byte
+	used     [Chip8RAMSize]bool
+	here     int
+	maxAddr  int
+	labels   map[string]int
+	consts   map[string]float64
+	aliases  map[string]uint8
+	macros   map[string]*octoMacro
+	fixups   []octoFixup
+	controls []*octoControl
+}
+
+var (
+	octoRegisterPattern = regexp.MustCompile(`^[vV]([0-9a-fA-F])$`)
+	octoFieldPattern    = regexp.MustCompile(`\S+`)
+)
+
+func tokenizeOcto(reader io.Reader) ([]octoToken, error) {
+	var tokens []octoToken
+	scanner := bufio.NewScanner(reader)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Text()
+		if index := strings.IndexByte(line, '#'); index != -1 {
+			line = line[:index]
+		}
+		for _, m := range octoFieldPattern.FindAllStringIndex(line, -1) {
+			tokens = append(tokens, octoToken{text: line[m[0]:m[1]], line: lineNum, col: m[0] + 1})
+		}
+	}
+	return tokens, scanner.Err()
+}
+
+func (c *OctoCompiler) errorf(line int, format string, args ...any) error {
+	return fmt.Errorf("line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+func (c *OctoCompiler) hasNext() bool {
+	return c.pos < len(c.tokens)
+}
+
+func (c *OctoCompiler) peek() string {
+	if c.hasNext() {
+		return c.tokens[c.pos].text
+	}
+	return ""
+}
+
+func (c *OctoCompiler) next() (octoToken, error) {
+	if !c.hasNext() {
+		line := 0
+		if len(c.tokens) > 0 {
+			line = c.tokens[len(c.tokens)-1].line
+		}
+		return octoToken{}, c.errorf(line, "unexpected end of source")
+	}
+	token := c.tokens[c.pos]
+	c.pos++
+	return token, nil
+}
+
+func (c *OctoCompiler) expect(text string) error {
+	token, err := c.next()
+	if err != nil {
+		return err
+	}
+	if token.text != text {
+		return c.errorf(token.line, "expect `%s', but `%s'", text, token.text)
+	}
+	return nil
+}
+
+func (c *OctoCompiler) emitByte(line int, b byte) error {
+	if c.here >= Chip8RAMSize {
+		return c.errorf(line, "program exceeds address 0x%03X", Chip8RAMSize-1)
+	}
+	if c.used[c.here] {
+		return c.errorf(line, "overwrite already emitted address 0x%03X", c.here)
+	}
+	c.rom[c.here] = b
+	c.used[c.here] = true
+	c.here++
+	if c.here > c.maxAddr {
+		c.maxAddr = c.here
+	}
+	return nil
+}
+
+func (c *OctoCompiler) emit(line int, word uint16) error {
+	if err := c.emitByte(line, byte(word>>8)); err != nil {
+		return err
+	}
+	return c.emitByte(line, byte(word))
+}
+
+func (c *OctoCompiler) emitIns(line int, op InstructionType, regs []uint8, value uint16) error {
+	return c.emit(line, EncodeInstruction(op, regs, value))
+}
+
+func (c *OctoCompiler) patch(addr int, target int) {
+	c.rom[addr] = c.rom[addr]&0xF0 | byte(target>>8)&0x0F
+	c.rom[addr+1] = byte(target)
+}
+
+func (c *OctoCompiler) register(token octoToken) (uint8, error) {
+	if reg, ok := c.aliases[token.text]; ok {
+		return reg, nil
+	}
+	if m := octoRegisterPattern.FindStringSubmatch(token.text); m != nil {
+		v, _ := strconv.ParseUint(m[1], 16, 8)
+		return uint8(v), nil
+	}
+	return 0, c.errorf(token.line, "require register, but `%s'", token.text)
+}
+
+func (c *OctoCompiler) isRegister(text string) bool {
+	_, ok := c.aliases[text]
+	return ok || octoRegisterPattern.MatchString(text)
+}
+
+func parseOctoNumber(text string) (float64, bool) {
+	negative := strings.HasPrefix(text, "-")
+	text = strings.TrimPrefix(text, "-")
+	var v uint64
+	var err error
+	switch { // leading zero is decimal, not octal
+	case strings.HasPrefix(text, "0b"):
+		v, err = strconv.ParseUint(text[2:], 2, 32)
+	case strings.HasPrefix(text, "0x"):
+		v, err = strconv.ParseUint(text[2:], 16, 32)
+	default:
+		v, err = strconv.ParseUint(text, 10, 32)
+	}
+	if err != nil {
+		return 0, false
+	}
+	if negative {
+		return -float64(v), true
+	}
+	return float64(v), true
+}
+
+// constant value of number, constant or defined label
+func (c *OctoCompiler) constant(text string) (float64, bool) {
+	if v, ok := parseOctoNumber(text); ok {
+		return v, true
+	}
+	if v, ok := c.consts[text]; ok {
+		return v, true
+	}
+	if v, ok := c.labels[text]; ok {
+		return float64(v), true
+	}
+	if text == "HERE" {
+		return float64(c.here), true
+	}
+	return 0, false
+}
+
+func (c *OctoCompiler) byteValue(token octoToken) (uint16, error) {
+	v, ok := c.constant(token.text)
+	if !ok {
+		return 0, c.errorf(token.line, "undefined constant: %s", token.text)
+	}
+	n := int(math.Floor(v))
+	if n < -128 || n > 255 {
+		return 0, c.errorf(token.line, "value must be byte: %s", token.text)
+	}
+	return uint16(n & 0xFF), nil
+}
+
+// emit instruction with 12-bit address. forward reference of label is resolved later
+func (c *OctoCompiler) emitAddrIns(op InstructionType, token octoToken) error {
+	if v, ok := c.constant(token.text); ok {
+		n := int(math.Floor(v))
+		if n < 0 || n > 0xFFF {
+			return c.errorf(token.line, "address out of range: %s", token.text)
+		}
+		return c.emitIns(token.line, op, nil, uint16(n))
+	}
+	if !identifierPattern.MatchString(token.text) {
+		return c.errorf(token.line, "invalid address: %s", token.text)
+	}
+	c.fixups = append(c.fixups, octoFixup{addr: c.here, label: token.text, line: token.line})
+	return c.emitIns(token.line, op, nil, 0)
+}
+
+var octoBinaryOps = map[string]InstructionType{
+	":=":  OP_8XY0,
+	"|=":  OP_8XY1,
+	"&=":  OP_8XY2,
+	"^=":  OP_8XY3,
+	"+=":  OP_8XY4,
+	"-=":  OP_8XY5,
+	">>=": OP_8XY6,
+	"=-":  OP_8XY7,
+	"<<=": OP_8XYE,
+}
+
+// compile `vX op ...` statement
+func (c *OctoCompiler) compileRegisterStatement(dest octoToken) error {
+	x, _ := c.register(dest)
+	opToken, err := c.next()
+	if err != nil {
+		return err
+	}
+	src, err := c.next()
+	if err != nil {
+		return err
+	}
+	line := dest.line
+	if c.isRegister(src.text) {
+		op, ok := octoBinaryOps[opToken.text]
+		if !ok {
+			return c.errorf(opToken.line, "unknown operator: %s", opToken.text)
+		}
+		y, _ := c.register(src)
+		return c.emitIns(line, op, []uint8{x, y}, 0)
+	}
+	switch opToken.text {
+	case ":=":
+		switch src.text {
+		case "key":
+			return c.emitIns(line, OP_FX0A, []uint8{x}, 0)
+		case "delay":
+			return c.emitIns(line, OP_FX07, []uint8{x}, 0)
+		case "random":
+			maskToken, err := c.next()
+			if err != nil {
+				return err
+			}
+			mask, err := c.byteValue(maskToken)
+			if err != nil {
+				return err
+			}
+			return c.emitIns(line, OP_CXNN, []uint8{x}, mask)
+		}
+		v, err := c.byteValue(src)
+		if err != nil {
+			return err
+		}
+		return c.emitIns(line, OP_6XNN, []uint8{x}, v)
+	case "+=", "-=":
+		v, err := c.byteValue(src)
+		if err != nil {
+			return err
+		}
+		if opToken.text == "-=" {
+			v = -v & 0xFF
+		}
+		return c.emitIns(line, OP_7XNN, []uint8{x}, v)
+	}
+	return c.errorf(opToken.line, "operator `%s' requires register", opToken.text)
+}
+
+// compile `i ...` statement
+func (c *OctoCompiler) compileIndexStatement(line int) error {
+	opToken, err := c.next()
+	if err != nil {
+		return err
+	}
+	src, err := c.next()
+	if err != nil {
+		return err
+	}
+	switch opToken.text {
+	case ":=":
+		if src.text == "hex" {
+			regToken, err := c.next()
+			if err != nil {
+				return err
+			}
+			x, err := c.register(regToken)
+			if err != nil {
+				return err
+			}
+			return c.emitIns(line, OP_FX29, []uint8{x}, 0)
+		}
+		return c.emitAddrIns(OP_ANNN, src)
+	case "+=":
+		x, err := c.register(src)
+		if err != nil {
+			return err
+		}
+		return c.emitIns(line, OP_FX1E, []uint8{x}, 0)
+	}
+	return c.errorf(opToken.line, "unknown operator for i: %s", opToken.text)
+}
+
+var octoInvertedCompare = map[string]string{
+	"==": "!=", "!=": "==", "key": "-key", "-key": "key", "<": ">=", ">=": "<", ">": "<=", "<=": ">",
+}
+
+// compile condition and emit instruction which skips next instruction when the condition is `skipWhen`
+func (c *OctoCompiler) compileCondition(skipWhen bool) error {
+	regToken, err := c.next()
+	if err != nil {
+		return err
+	}
+	x, err := c.register(regToken)
+	if err != nil {
+		return err
+	}
+	cmpToken, err := c.next()
+	if err != nil {
+		return err
+	}
+	cmp := cmpToken.text
+	if _, ok := octoInvertedCompare[cmp]; !ok {
+		return c.errorf(cmpToken.line, "unknown comparison: %s", cmp)
+	}
+	if !skipWhen {
+		cmp = octoInvertedCompare[cmp]
+	}
+	line := regToken.line
+	switch cmp {
+	case "key":
+		return c.emitIns(line, OP_EX9E, []uint8{x}, 0)
+	case "-key":
+		return c.emitIns(line, OP_EXA1, []uint8{x}, 0)
+	}
+
+	rhs, err := c.next()
+	if err != nil {
+		return err
+	}
+	if cmp == "==" || cmp == "!=" {
+		if c.isRegister(rhs.text) {
+			y, _ := c.register(rhs)
+			return c.emitIns(line, map[string]InstructionType{"==": OP_5XY0, "!=": OP_9XY0}[cmp], []uint8{x, y}, 0)
+		}
+		v, err := c.byteValue(rhs)
+		if err != nil {
+			return err
+		}
+		return c.emitIns(line, map[string]InstructionType{"==": OP_3XNN, "!=": OP_4XNN}[cmp], []uint8{x}, v)
+	}
+
+	// magnitude comparison with vf. vf holds `no borrow' flag of subtraction
+	less := cmp == "<" || cmp == ">="
+	if c.isRegister(rhs.text) {
+		y, _ := c.register(rhs)
+		a, b := x, y // vf := a - b, then vf == 1 if a >= b
+		if !less {
+			a, b = y, x
+		}
+		if err := c.emitIns(line, OP_8XY0, []uint8{0xF, a}, 0); err != nil {
+			return err
+		}
+		if err := c.emitIns(line, OP_8XY5, []uint8{0xF, b}, 0); err != nil {
+			return err
+		}
+	} else {
+		v, err := c.byteValue(rhs)
+		if err != nil {
+			return err
+		}
+		if err := c.emitIns(line, OP_6XNN, []uint8{0xF}, v); err != nil {
+			return err
+		}
+		op := OP_8XY7 // vf := x - n
+		if !less {
+			op = OP_8XY5 // vf := n - x
+		}
+		if err := c.emitIns(line, op, []uint8{0xF, x}, 0); err != nil {
+			return err
+		}
+	}
+	// now vf == 0 if `<' or `>' holds
+	if cmp == "<" || cmp == ">" {
+		return c.emitIns(line, OP_3XNN, []uint8{0xF}, 0)
+	}
+	return c.emitIns(line, OP_4XNN, []uint8{0xF}, 0)
+}
+
+func (c *OctoCompiler) compileIf(line int) error {
+	// find `then' or `begin' to decide skip sense
+	depth := c.pos
+	for depth < len(c.tokens) && c.tokens[depth].text != "then" && c.tokens[depth].text != "begin" {
+		depth++
+	}
+	if depth == len(c.tokens) {
+		return c.errorf(line, "`if' requires `then' or `begin'")
+	}
+	if c.tokens[depth].text == "then" {
+		// skip next statement unless condition holds
+		if err := c.compileCondition(false); err != nil {
+			return err
+		}
+		if err := c.expect("then"); err != nil {
+			return err
+		}
+		return c.compileStatement()
+	}
+
+	// skip jump to else/end when condition holds
+	if err := c.compileCondition(true); err != nil {
+		return err
+	}
+	if err := c.expect("begin"); err != nil {
+		return err
+	}
+	c.controls = append(c.controls, &octoControl{kind: OCTO_BEGIN, addr: c.here, line: line})
+	return c.emitIns(line, OP_1NNN, nil, 0)
+}
+
+func (c *OctoCompiler) popControl(line int, kinds ...octoControlKind) (*octoControl, error) {
+	if len(c.controls) > 0 {
+		control := c.controls[len(c.controls)-1]
+		for _, kind := range kinds {
+			if control.kind == kind {
+				c.controls = c.controls[:len(c.controls)-1]
+				return control, nil
+			}
+		}
+	}
+	return nil, c.errorf(line, "unbalanced control statement")
+}
+
+func (c *OctoCompiler) compileDirective(token octoToken) error {
+	line := token.line
+	switch token.text {
+	case ":":
+		name, err := c.next()
+		if err != nil {
+			return err
+		}
+		return c.defineLabel(name)
+	case ":const", ":calc":
+		name, err := c.next()
+		if err != nil {
+			return err
+		}
+		var v float64
+		if token.text == ":const" {
+			valueToken, err := c.next()
+			if err != nil {
+				return err
+			}
+			var ok bool
+			if v, ok = c.constant(valueToken.text); !ok {
+				return c.errorf(valueToken.line, "undefined constant: %s", valueToken.text)
+			}
+		} else if v, err = c.calc(); err != nil {
+			return err
+		}
+		if !identifierPattern.MatchString(name.text) {
+			return c.errorf(name.line, "invalid constant name: %s", name.text)
+		}
+		c.consts[name.text] = v
+		return nil
+	case ":alias":
+		name, err := c.next()
+		if err != nil {
+			return err
+		}
+		regToken, err := c.next()
+		if err != nil {
+			return err
+		}
+		reg, err := c.register(regToken)
+		if err != nil {
+			return err
+		}
+		c.aliases[name.text] = reg
+		return nil
+	case ":macro":
+		return c.defineMacro()
+	case ":org":
+		addrToken, err := c.next()
+		if err != nil {
+			return err
+		}
+		v, ok := c.constant(addrToken.text)
+		if !ok || v < Chip8ProgStartAddr || v >= Chip8RAMSize {
+			return c.errorf(addrToken.line, "invalid address: %s", addrToken.text)
+		}
+		c.here = int(v)
+		return nil
+	case ":byte":
+		var v float64
+		if c.peek() == "{" {
+			var err error
+			if v, err = c.calc(); err != nil {
+				return err
+			}
+		} else {
+			valueToken, err := c.next()
+			if err != nil {
+				return err
+			}
+			b, err := c.byteValue(valueToken)
+			if err != nil {
+				return err
+			}
+			v = float64(b)
+		}
+		return c.emitByte(line, byte(int(math.Floor(v))))
+	case ":call":
+		target, err := c.next()
+		if err != nil {
+			return err
+		}
+		return c.emitAddrIns(OP_2NNN, target)
+	}
+	return c.errorf(line, "unsupported directive: %s", token.text)
+}
+
+var octoCalcPrecedences = map[string]int{
+	"|": 1, "^": 2, "&": 3, "<<": 4, ">>": 4, "+": 5, "-": 5, "*": 6, "/": 6, "%": 6,
+}
+
+// calc evaluate expression enclosed by braces
+func (c *OctoCompiler) calc() (float64, error) {
+	line := 0
+	if c.hasNext() {
+		line = c.tokens[c.pos].line
+	}
+	tokens, err := c.block()
+	if err != nil {
+		return 0, err
+	}
+	pos := 0
+	var binary func(minPrec int) (float64, error)
+	primary := func() (float64, error) {
+		if pos >= len(tokens) {
+			return 0, c.errorf(line, "incomplete expression")
+		}
+		token := tokens[pos]
+		pos++
+		switch token.text {
+		case "-":
+			v, err := binary(7)
+			return -v, err
+		case "(":
+			v, err := binary(1)
+			if err != nil {
+				return 0, err
+			}
+			if pos >= len(tokens) || tokens[pos].text != ")" {
+				return 0, c.errorf(token.line, "require `)'")
+			}
+			pos++
+			return v, nil
+		}
+		if v, ok := c.constant(token.text); ok {
+			return v, nil
+		}
+		return 0, c.errorf(token.line, "undefined constant: %s", token.text)
+	}
+	binary = func(minPrec int) (float64, error) {
+		left, err := primary()
+		if err != nil {
+			return 0, err
+		}
+		for pos < len(tokens) {
+			op := tokens[pos].text
+			prec, ok := octoCalcPrecedences[op]
+			if !ok || prec < minPrec {
+				break
+			}
+			pos++
+			right, err := binary(prec + 1)
+			if err != nil {
+				return 0, err
+			}
+			a, b := int64(math.Floor(left)), int64(math.Floor(right))
+			switch op {
+			case "|":
+				left = float64(a | b)
+			case "^":
+				left = float64(a ^ b)
+			case "&":
+				left = float64(a & b)
+			case "<<":
+				left = float64(a << uint64(b))
+			case ">>":
+				left = float64(a >> uint64(b))
+			case "+":
+				left += right
+			case "-":
+				left -= right
+			case "*":
+				left *= right
+			case "/", "%":
+				if right == 0 {
+					return 0, c.errorf(tokens[pos-1].line, "division by zero")
+				}
+				if op == "/" {
+					left /= right
+				} else {
+					left = math.Mod(left, right)
+				}
+			}
+		}
+		return left, nil
+	}
+	v, err := binary(1)
+	if err != nil {
+		return 0, err
+	}
+	if pos < len(tokens) {
+		return 0, c.errorf(tokens[pos].line, "unexpected token in expression: %s", tokens[pos].text)
+	}
+	return v, nil
+}
+
+func (c *OctoCompiler) defineLabel(name octoToken) error {
+	if !identifierPattern.MatchString(name.text) {
+		return c.errorf(name.line, "invalid label name: %s", name.text)
+	}
+	if _, ok := c.labels[name.text]; ok {
+		return c.errorf(name.line, "duplicated label: %s", name.text)
+	}
+	c.labels[name.text] = c.here
+	return nil
+}
+
+func (c *OctoCompiler) defineMacro() error {
+	name, err := c.next()
+	if err != nil {
+		return err
+	}
+	macro := &octoMacro{}
+	for c.peek() != "{" {
+		arg, err := c.next()
+		if err != nil {
+			return err
+		}
+		macro.args = append(macro.args, arg.text)
+	}
+	body, err := c.block()
+	if err != nil {
+		return err
+	}
+	macro.body = body
+	c.macros[name.text] = macro
+	return nil
+}
+
+// block read tokens enclosed by braces
+func (c *OctoCompiler) block() ([]octoToken, error) {
+	if err := c.expect("{"); err != nil {
+		return nil, err
+	}
+	var body []octoToken
+	depth := 1
+	for {
+		token, err := c.next()
+		if err != nil {
+			return nil, err
+		}
+		if token.text == "{" {
+			depth++
+		} else if token.text == "}" {
+			depth--
+			if depth == 0 {
+				return body, nil
+			}
+		}
+		body = append(body, token)
+	}
+}
+
+func (c *OctoCompiler) expandMacro(token octoToken, macro *octoMacro) error {
+	bindings := make(map[string]string)
+	for _, arg := range macro.args {
+		value, err := c.next()
+		if err != nil {
+			return err
+		}
+		bindings[arg] = value.text
+	}
+	var expanded []octoToken
+	for _, t := range macro.body {
+		if v, ok := bindings[t.text]; ok {
+			t.text = v
+		}
+		t.line = token.line
+		expanded = append(expanded, t)
+	}
+	rest := append(expanded, c.tokens[c.pos:]...)
+	c.tokens = append(c.tokens[:c.pos:c.pos], rest...)
+	return nil
+}
+
+func (c *OctoCompiler) compileStatement() error {
+	token, err := c.next()
+	if err != nil {
+		return err
+	}
+	line := token.line
+	text := token.text
+	switch {
+	case strings.HasPrefix(text, ":") && text != ":=":
+		return c.compileDirective(token)
+	case c.isRegister(text):
+		return c.compileRegisterStatement(token)
+	}
+	if v, ok := parseOctoNumber(text); ok {
+		if v < -128 || v > 255 {
+			return c.errorf(line, "value must be byte: %s", text)
+		}
+		return c.emitByte(line, byte(int(v)))
+	}
+
+	switch text {
+	case "clear":
+		return c.emitIns(line, OP_00E0, nil, 0)
+	case "return", ";":
+		return c.emitIns(line, OP_00EE, nil, 0)
+	case "jump", "jump0", "native":
+		target, err := c.next()
+		if err != nil {
+			return err
+		}
+		op := map[string]InstructionType{"jump": OP_1NNN, "jump0": OP_BNNN, "native": OP_0NNN}[text]
+		return c.emitAddrIns(op, target)
+	case "i":
+		return c.compileIndexStatement(line)
+	case "delay", "buzzer":
+		if err := c.expect(":="); err != nil {
+			return err
+		}
+		regToken, err := c.next()
+		if err != nil {
+			return err
+		}
+		x, err := c.register(regToken)
+		if err != nil {
+			return err
+		}
+		op := map[string]InstructionType{"delay": OP_FX15, "buzzer": OP_FX18}[text]
+		return c.emitIns(line, op, []uint8{x}, 0)
+	case "bcd", "save", "load":
+		regToken, err := c.next()
+		if err != nil {
+			return err
+		}
+		x, err := c.register(regToken)
+		if err != nil {
+			return err
+		}
+		op := map[string]InstructionType{"bcd": OP_FX33, "save": OP_FX55, "load": OP_FX65}[text]
+		return c.emitIns(line, op, []uint8{x}, 0)
+	case "sprite":
+		var operands [3]octoToken
+		for i := range operands {
+			if operands[i], err = c.next(); err != nil {
+				return err
+			}
+		}
+		x, err := c.register(operands[0])
+		if err != nil {
+			return err
+		}
+		y, err := c.register(operands[1])
+		if err != nil {
+			return err
+		}
+		n, err := c.byteValue(operands[2])
+		if err != nil {
+			return err
+		}
+		if n > 0xF {
+			return c.errorf(line, "sprite height must be 0-15: %s", operands[2].text)
+		}
+		return c.emitIns(line, OP_DXYN, []uint8{x, y}, n)
+	case "if":
+		return c.compileIf(line)
+	case "else":
+		control, err := c.popControl(line, OCTO_BEGIN)
+		if err != nil {
+			return err
+		}
+		c.controls = append(c.controls, &octoControl{kind: OCTO_ELSE, addr: c.here, line: line})
+		if err := c.emitIns(line, OP_1NNN, nil, 0); err != nil {
+			return err
+		}
+		c.patch(control.addr, c.here)
+		return nil
+	case "end":
+		control, err := c.popControl(line, OCTO_BEGIN, OCTO_ELSE)
+		if err != nil {
+			return err
+		}
+		c.patch(control.addr, c.here)
+		return nil
+	case "loop":
+		c.controls = append(c.controls, &octoControl{kind: OCTO_LOOP, addr: c.here, line: line})
+		return nil
+	case "while":
+		var loop *octoControl
+		for i := len(c.controls) - 1; i >= 0; i-- {
+			if c.controls[i].kind == OCTO_LOOP {
+				loop = c.controls[i]
+				break
+			}
+		}
+		if loop == nil {
+			return c.errorf(line, "`while' must be in loop")
+		}
+		// exit loop unless the condition holds
+		if err := c.compileCondition(true); err != nil {
+			return err
+		}
+		loop.breakers = append(loop.breakers, c.here)
+		return c.emitIns(line, OP_1NNN, nil, 0)
+	case "again":
+		control, err := c.popControl(line, OCTO_LOOP)
+		if err != nil {
+			return err
+		}
+		if err := c.emitIns(line, OP_1NNN, nil, uint16(control.addr)); err != nil {
+			return err
+		}
+		for _, addr := range control.breakers {
+			c.patch(addr, c.here)
+		}
+		return nil
+	}
+
+	if macro, ok := c.macros[text]; ok {
+		return c.expandMacro(token, macro)
+	}
+	if identifierPattern.MatchString(text) { // call subroutine
+		return c.emitAddrIns(OP_2NNN, token)
+	}
+	return c.errorf(line, "unknown statement: %s", text)
+}
+
+func (c *OctoCompiler) compile(tokens []octoToken, jumpToMain bool) error {
+	c.tokens = tokens
+	c.pos = 0
+	c.here = Chip8ProgStartAddr
+	c.maxAddr = Chip8ProgStartAddr
+	c.labels = make(map[string]int)
+	c.consts = make(map[string]float64)
+	c.aliases = make(map[string]uint8)
+	c.macros = make(map[string]*octoMacro)
+	c.rom = [Chip8RAMSize]byte{}
+	c.used = [Chip8RAMSize]bool{}
+	c.fixups = nil
+	c.controls = nil
+	if jumpToMain {
+		c.fixups = append(c.fixups, octoFixup{addr: c.here, label: "main", line: 1})
+		if err := c.emitIns(1, OP_1NNN, nil, 0); err != nil {
+			return err
+		}
+	}
+	for c.hasNext() {
+		if err := c.compileStatement(); err != nil {
+			return err
+		}
+	}
+	if len(c.controls) > 0 {
+		return c.errorf(c.controls[len(c.controls)-1].line, "unclosed control statement")
+	}
+	for _, fixup := range c.fixups {
+		addr, ok := c.labels[fixup.label]
+		if !ok {
+			return c.errorf(fixup.line, "undefined label: %s", fixup.label)
+		}
+		c.patch(fixup.addr, addr)
+	}
+	return nil
+}
+
+// CompileOcto compile Octo source into CHIP-8 program.
+// execution starts at `main' label. jump to main is omitted if main is placed at program start
+func CompileOcto(reader io.Reader, writer io.Writer) error {
+	tokens, err := tokenizeOcto(reader)
+	if err != nil {
+		return err
+	}
+	compiler := OctoCompiler{}
+	if err := compiler.compile(tokens, false); err != nil {
+		return err
+	}
+	if main, ok := compiler.labels["main"]; !ok || main != Chip8ProgStartAddr {
+		if err := compiler.compile(tokens, true); err != nil {
+			return err
+		}
+	}
+	_, err = writer.Write(compiler.rom[Chip8ProgStartAddr:compiler.maxAddr])
+	return err
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseOctoNumber(t *testing.T) {
+	tests := []struct {
+		text string
+		want float64
+		ok   bool
+	}{
+		{"10", 10, true},
+		{"010", 10, true}, // not octal
+		{"0x1F", 31, true},
+		{"0b101", 5, true},
+		{"-3", -3, true},
+		{"-0x10", -16, true},
+		{"0o17", 0, false},
+		{"1_000", 0, false},
+		{"0x", 0, false},
+		{"v0", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseOctoNumber(tt.text)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("parseOctoNumber(%q) = %v, %v, want %v, %v", tt.text, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,491 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// optimizer passes
+const (
+	OPT_THREAD_JUMP = iota
+	OPT_JUMP_TO_NEXT
+	OPT_DEAD_CODE
+	OPT_FOLD_LOAD_ADD
+	OPT_LOAD_I
+	OPT_UNUSED_DATA
+	OPT_PASS_COUNT
+)
+
+type optimizeStat struct {
+	name  string
+	unit  string
+	count int
+	bytes int
+}
+
+// optItem is a statement emitting bytes with labels pointing to it
+type optItem struct {
+	stmt   *asmStatement
+	labels []string        // own labels and labels of preceding label-only statements
+	op     InstructionType // OP_INVALID for data and raw word
+}
+
+// Optimizer rewrites assembled program by peephole optimizations.
+// statements are edited in place and re-linked, so code and data can move as long as they are referred by labels
+type Optimizer struct {
+	a          *Assembler
+	items      [][]*optItem // per section
+	at         map[uint16]*optItem
+	referenced map[string]bool // qualified names referred by any operand
+	indirect   bool            // JP V0 is used, so jump tables must not be changed
+	indexed    bool            // I is computed by ADD I or address arithmetic, so adjacent data may be reached
+	labelCount int
+	sizeBefore int
+	stats      [OPT_PASS_COUNT]optimizeStat
+}
+
+func NewOptimizer(a *Assembler) *Optimizer {
+	return &Optimizer{
+		a: a,
+		stats: [OPT_PASS_COUNT]optimizeStat{
+			OPT_THREAD_JUMP:   {name: "jump threading", unit: "jump(s) retargeted"},
+			OPT_JUMP_TO_NEXT:  {name: "jump to next", unit: "instruction(s)"},
+			OPT_DEAD_CODE:     {name: "dead code", unit: "instruction(s)"},
+			OPT_FOLD_LOAD_ADD: {name: "load/add folding", unit: "instruction(s)"},
+			OPT_LOAD_I:        {name: "redundant LD I", unit: "instruction(s)"},
+			OPT_UNUSED_DATA:   {name: "unused data", unit: "block(s)"},
+		},
+	}
+}
+
+// DisassembleForOptimize convert ROM into assembly whose code and data are addressed by labels.
+// bytes unreachable from program entry are treated as data, and targets of LD I are labeled
+func DisassembleForOptimize(name string, buf []byte, annotation *Annotation) (*Assembler, error) {
+	generated := NewAnnotation()
+	code := make(map[uint16]bool)
+	for _, sub := range BuildCFG(buf, annotation).subroutines {
+		for _, block := range sub.blocks {
+			for _, ins := range block.instructions {
+				addr := ins.Address()
+				switch ins.Type() {
+				case OP_BNNN:
+					return nil, fmt.Errorf("indirect jump (JP V0) at 0x%03X: jump table cannot be relocated", addr)
+				case OP_ANNN:
+					target := ins.(AddrIns).target
+					if target >= Chip8ProgStartAddr && int(target) < Chip8ProgStartAddr+len(buf) {
+						generated.labels[target] = fmt.Sprintf("data_%03x", target)
+					}
+				}
+				code[addr] = true
+				code[addr+1] = true
+			}
+		}
+	}
+	for i := 0; i < len(buf); i++ {
+		addr := uint16(Chip8ProgStartAddr + i)
+		if code[addr] {
+			continue
+		}
+		if n := len(generated.regions); n > 0 && generated.regions[n-1].end+1 == addr {
+			generated.regions[n-1].end = addr
+		} else {
+			generated.regions = append(generated.regions, AnnotationRegion{kind: REGION_DATA, start: addr, end: addr})
+		}
+	}
+	if annotation != nil { // user annotation has priority
+		for addr, label := range annotation.labels {
+			generated.labels[addr] = label
+		}
+		generated.subroutines = annotation.subroutines
+		generated.comments = annotation.comments
+		generated.regions = append(generated.regions, annotation.regions...)
+	}
+
+	var source bytes.Buffer
+	if err := Disassemble(bytes.NewReader(buf), &source, DisasmOption{Annotation: generated}); err != nil {
+		return nil, err
+	}
+	a := NewAssembler()
+	if err := a.Parse(name, &source, ""); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// link re-link program and collect statements, addresses and referenced labels
+func (o *Optimizer) link() error {
+	o.a.labels = make(map[string]uint16)
+	for _, c := range o.a.consts { // constants may depend on moved labels
+		c.state = 0
+	}
+	if err := o.a.Link(); err != nil {
+		return err
+	}
+	o.referenced = make(map[string]bool)
+	for _, stmt := range o.a.statements {
+		for _, operand := range stmt.operands {
+			for _, name := range symbolTerms(operand.expr) {
+				o.referenced[qualifyLabel(stmt.scope, name)] = true
+			}
+		}
+	}
+	o.items = nil
+	o.at = make(map[uint16]*optItem)
+	for _, section := range o.a.sections {
+		var items []*optItem
+		var labels []string
+		for _, stmt := range section.statements {
+			labels = append(labels, stmt.labels...)
+			if stmt.size == 0 {
+				continue
+			}
+			item := &optItem{stmt: stmt, labels: labels, op: OP_INVALID}
+			if !stmt.isData() && !stmt.rawWord {
+				item.op, _, _ = lookupInstruction(stmt.mnemonic, stmt.operands)
+			}
+			switch {
+			case item.op == OP_BNNN:
+				o.indirect = true
+			case item.op == OP_FX1E:
+				o.indexed = true
+			case item.op == OP_ANNN && strings.ContainsAny(strings.TrimPrefix(stmt.operands[1].expr, "-"), "+-"):
+				o.indexed = true
+			}
+			items = append(items, item)
+			o.at[stmt.addr] = item
+			labels = nil
+		}
+		o.items = append(o.items, items)
+	}
+	return nil
+}
+
+// checkRelocatable reports address operands written as number, since they are not updated when code moves
+func (o *Optimizer) checkRelocatable() error {
+	end := Chip8ProgStartAddr + o.a.Size()
+	for _, items := range o.items {
+		for _, item := range items {
+			switch item.op {
+			case OP_1NNN, OP_2NNN, OP_ANNN, OP_BNNN:
+			default:
+				continue
+			}
+			operand := item.stmt.operands[len(item.stmt.operands)-1]
+			addr, ok := o.target(item)
+			if ok && len(symbolTerms(operand.expr)) == 0 && addr >= Chip8ProgStartAddr && int(addr) < end {
+				o.a.report(item.stmt.operandErrorf(operand,
+					"address inside program must be written as label to be relocated: %s", operand.expr))
+			}
+		}
+	}
+	return o.a.checkDiagnostics()
+}
+
+// target returns address operand value of JP, CALL or LD I
+func (o *Optimizer) target(item *optItem) (uint16, bool) {
+	v, err := o.a.evalExpr(item.stmt, item.stmt.operands[len(item.stmt.operands)-1], 0xFFF)
+	return v, err == nil
+}
+
+// entry reports whether the item may be reached by jump, call or LD I
+func (o *Optimizer) entry(item *optItem) bool {
+	for _, label := range item.labels {
+		if o.referenced[label] {
+			return true
+		}
+	}
+	return false
+}
+
+// conditional reports whether the item may be skipped by preceding skip instruction
+func conditional(items []*optItem, index int) bool {
+	return index > 0 && isSkipInstruction(items[index-1].op)
+}
+
+func removed(item *optItem) bool {
+	return item.stmt.mnemonic == ""
+}
+
+// remove turn statement into label-only statement, so its labels point to the next statement
+func (o *Optimizer) remove(item *optItem, pass int) {
+	o.stats[pass].bytes += item.stmt.size
+	item.stmt.mnemonic = ""
+	item.stmt.operands = nil
+	item.stmt.rawWord = false
+	item.stmt.data = nil
+}
+
+// labelFor returns label name referring the item from the statement. label is added if none is usable
+func (o *Optimizer) labelFor(item *optItem, from *asmStatement) string {
+	for _, label := range item.labels {
+		if !strings.Contains(label, ".") {
+			return label
+		}
+	}
+	for _, label := range item.labels {
+		if from.scope != "" && strings.HasPrefix(label, from.scope+".") {
+			return strings.TrimPrefix(label, from.scope)
+		}
+	}
+	for {
+		o.labelCount++
+		label := fmt.Sprintf("_opt%d", o.labelCount)
+		if _, ok := o.a.labels[label]; ok {
+			continue
+		}
+		if _, ok := o.a.consts[label]; ok {
+			continue
+		}
+		item.stmt.labels = append(item.stmt.labels, label)
+		item.stmt.labelCols = append(item.stmt.labelCols, 0)
+		item.labels = append(item.labels, label)
+		return label
+	}
+}
+
+// threadJumps retarget JP and CALL to the final destination of JP chain
+func (o *Optimizer) threadJumps() bool {
+	changed := false
+	for _, items := range o.items {
+		for _, item := range items {
+			if (item.op != OP_1NNN && item.op != OP_2NNN) || removed(item) {
+				continue
+			}
+			addr, ok := o.target(item)
+			if !ok {
+				continue
+			}
+			var to *optItem
+			seen := make(map[*optItem]bool)
+			for t := o.at[addr]; t != nil && t.op == OP_1NNN && !seen[t]; t = to {
+				seen[t] = true
+				next, ok := o.target(t)
+				if !ok || o.at[next] == nil {
+					break
+				}
+				to = o.at[next]
+			}
+			if to == nil || to.stmt.addr == addr {
+				continue
+			}
+			operand := &item.stmt.operands[len(item.stmt.operands)-1]
+			operand.expr = o.labelFor(to, item.stmt)
+			o.stats[OPT_THREAD_JUMP].count++
+			changed = true
+		}
+	}
+	return changed
+}
+
+// removeJumpToNext remove JP to the next instruction
+func (o *Optimizer) removeJumpToNext() bool {
+	if o.indirect {
+		return false
+	}
+	changed := false
+	for _, items := range o.items {
+		for i, item := range items {
+			if item.op != OP_1NNN || removed(item) || i+1 >= len(items) || conditional(items, i) {
+				continue
+			}
+			if addr, ok := o.target(item); ok && addr == items[i+1].stmt.addr {
+				o.remove(item, OPT_JUMP_TO_NEXT)
+				o.stats[OPT_JUMP_TO_NEXT].count++
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// removeDeadCode remove instructions after JP or RET until a referenced label.
+// disabled if JP V0 is used, since instructions of jump table are reached only by computed jump
+func (o *Optimizer) removeDeadCode() bool {
+	if o.indirect {
+		return false
+	}
+	changed := false
+	for _, items := range o.items {
+		for i, item := range items {
+			if (item.op != OP_1NNN && item.op != OP_00EE) || removed(item) || conditional(items, i) {
+				continue
+			}
+			for _, next := range items[i+1:] {
+				if o.entry(next) || next.op == OP_INVALID {
+					break
+				}
+				if !removed(next) {
+					o.remove(next, OPT_DEAD_CODE)
+					o.stats[OPT_DEAD_CODE].count++
+					changed = true
+				}
+			}
+		}
+	}
+	return changed
+}
+
+// foldLoadAdd fold LD Vx, a; ADD Vx, b into LD Vx, a+b
+func (o *Optimizer) foldLoadAdd() bool {
+	changed := false
+	for _, items := range o.items {
+		for i := 0; i+1 < len(items); i++ {
+			load, add := items[i], items[i+1]
+			if load.op != OP_6XNN || add.op != OP_7XNN || removed(load) || removed(add) ||
+				load.stmt.operands[0].reg != add.stmt.operands[0].reg || o.entry(add) || conditional(items, i) {
+				continue
+			}
+			a, errA := o.a.evalExpr(load.stmt, load.stmt.operands[1], 0xFF)
+			b, errB := o.a.evalExpr(add.stmt, add.stmt.operands[1], 0xFF)
+			if errA != nil || errB != nil {
+				continue
+			}
+			load.stmt.operands[1].expr = fmt.Sprintf("0x%02X", (a+b)&0xFF)
+			o.remove(add, OPT_FOLD_LOAD_ADD)
+			o.stats[OPT_FOLD_LOAD_ADD].count++
+			changed = true
+			i++
+		}
+	}
+	return changed
+}
+
+// removeRedundantLoadI remove LD I which loads the value I already has,
+// and LD I overwritten by the following LD I before I is used
+func (o *Optimizer) removeRedundantLoadI() bool {
+	changed := false
+	for _, items := range o.items {
+		known := false // value of I is known on straight-line path
+		value := uint16(0)
+		var last *optItem // LD I whose value is not used yet
+		for i, item := range items {
+			if removed(item) {
+				continue
+			}
+			if o.entry(item) {
+				known = false
+			}
+			cond := conditional(items, i)
+			switch item.op {
+			case OP_ANNN:
+				v, ok := o.target(item)
+				if !ok {
+					known, last = false, nil
+					continue
+				}
+				if known && v == value && !cond {
+					o.remove(item, OPT_LOAD_I)
+					o.stats[OPT_LOAD_I].count++
+					changed = true
+					continue
+				}
+				if last != nil && !cond {
+					o.remove(last, OPT_LOAD_I)
+					o.stats[OPT_LOAD_I].count++
+					changed = true
+				}
+				known, value, last = !cond, v, item
+				if cond { // previous value of I remains if skipped
+					last = nil
+				}
+			case OP_DXYN, OP_FX33:
+				last = nil
+			case OP_FX1E, OP_FX29, OP_FX55, OP_FX65: // FX55 and FX65 increment I on COSMAC VIP and Octo
+				known, last = false, nil
+			case OP_00E0, OP_3XNN, OP_4XNN, OP_5XY0, OP_6XNN, OP_7XNN, OP_8XY0, OP_8XY1, OP_8XY2, OP_8XY3, OP_8XY4,
+				OP_8XY5, OP_8XY6, OP_8XY7, OP_8XYE, OP_9XY0, OP_CXNN, OP_EX9E, OP_EXA1, OP_FX07, OP_FX0A, OP_FX15, OP_FX18:
+			default: // control flow leaves, or data
+				known, last = false, nil
+			}
+		}
+	}
+	return changed
+}
+
+// removeUnusedData remove data blocks whose labels are not referenced, and unlabeled data after JP or RET.
+// if I is computed, data following other data or unlabeled data is kept, since it may be indexed
+func (o *Optimizer) removeUnusedData() bool {
+	changed := false
+	for _, items := range o.items {
+		for i := 0; i < len(items); i++ {
+			item := items[i]
+			if !item.stmt.isData() || removed(item) || o.entry(item) {
+				continue
+			}
+			if len(item.labels) == 0 {
+				if o.indexed || i == 0 || (items[i-1].op != OP_1NNN && items[i-1].op != OP_00EE) ||
+					conditional(items, i-1) {
+					continue
+				}
+			} else if o.indexed && i > 0 && items[i-1].stmt.isData() {
+				continue
+			}
+			o.remove(item, OPT_UNUSED_DATA)
+			for i+1 < len(items) && items[i+1].stmt.isData() && len(items[i+1].labels) == 0 {
+				i++
+				o.remove(items[i], OPT_UNUSED_DATA)
+			}
+			o.stats[OPT_UNUSED_DATA].count++
+			changed = true
+		}
+	}
+	return changed
+}
+
+// Optimize apply passes until nothing changes, then write optimized program
+func (o *Optimizer) Optimize(writer io.Writer) error {
+	if err := o.link(); err != nil {
+		return err
+	}
+	if err := o.a.Encode(io.Discard); err != nil {
+		return err
+	}
+	o.a.diagnostics = nil // warnings are reported again by the last encode
+	if err := o.checkRelocatable(); err != nil {
+		return err
+	}
+	o.sizeBefore = o.a.Size()
+
+	passes := []func() bool{
+		o.threadJumps,
+		o.removeJumpToNext,
+		o.removeDeadCode,
+		o.foldLoadAdd,
+		o.removeRedundantLoadI,
+		o.removeUnusedData,
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, pass := range passes {
+			if err := o.link(); err != nil {
+				return err
+			}
+			if pass() {
+				changed = true
+			}
+		}
+	}
+	if err := o.link(); err != nil {
+		return err
+	}
+	return o.a.Encode(writer)
+}
+
+// WriteReport write applied optimizations and saved bytes
+func (o *Optimizer) WriteReport(writer io.Writer) error {
+	for _, stat := range o.stats {
+		if stat.count == 0 {
+			continue
+		}
+		line := fmt.Sprintf("%-18s %d %s", stat.name+":", stat.count, stat.unit)
+		if stat.bytes > 0 {
+			line += fmt.Sprintf(", %d bytes", stat.bytes)
+		}
+		_, _ = fmt.Fprintln(writer, line)
+	}
+	after := o.a.Size()
+	_, err := fmt.Fprintf(writer, "saved %d bytes (%d -> %d bytes)\n", o.sizeBefore-after, o.sizeBefore, after)
+	return err
+}
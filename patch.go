@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	ipsHeader       = "PATCH"
+	ipsFooter       = "EOF"
+	ipsMaxOffset    = 0xFFFFFF
+	ipsMaxRecordLen = 0xFFFF
+	bpsHeader       = "BPS1"
+)
+
+type PatchFormat string
+
+const (
+	PATCH_IPS PatchFormat = "ips"
+	PATCH_BPS PatchFormat = "bps"
+)
+
+// PatchFormatFromPath guess patch format from file extension
+func PatchFormatFromPath(path string) (PatchFormat, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".ips":
+		return PATCH_IPS, nil
+	case ".bps":
+		return PATCH_BPS, nil
+	}
+	return "", fmt.Errorf("unknown patch format: %s", path)
+}
+
+// ApplyPatch apply IPS or BPS patch to ROM. format is detected by header
+func ApplyPatch(rom []byte, patch []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(patch, []byte(ipsHeader)):
+		return ApplyIPS(rom, patch)
+	case bytes.HasPrefix(patch, []byte(bpsHeader)):
+		return ApplyBPS(rom, patch)
+	}
+	return nil, errors.New("unknown patch format (IPS and BPS are supported)")
+}
+
+func MakePatch(format PatchFormat, source []byte, target []byte) ([]byte, error) {
+	switch format {
+	case PATCH_IPS:
+		return MakeIPS(source, target)
+	case PATCH_BPS:
+		return MakeBPS(source, target), nil
+	}
+	return nil, fmt.Errorf("unknown patch format: %s", format)
+}
+
+// patchReader reads patch content with bounds check
+type patchReader struct {
+	buf    []byte
+	offset int
+}
+
+var errPatchTruncated = errors.New("broken patch: unexpected end of patch")
+
+func (r *patchReader) read(n int) ([]byte, error) {
+	if n < 0 || r.offset+n > len(r.buf) {
+		return nil, errPatchTruncated
+	}
+	b := r.buf[r.offset : r.offset+n]
+	r.offset += n
+	return b, nil
+}
+
+// readUint read n bytes big-endian integer
+func (r *patchReader) readUint(n int) (int, error) {
+	b, err := r.read(n)
+	if err != nil {
+		return 0, err
+	}
+	v := 0
+	for _, c := range b {
+		v = v<<8 | int(c)
+	}
+	return v, nil
+}
+
+// readVarint read BPS variable-length integer
+func (r *patchReader) readVarint() (int, error) {
+	data, shift := 0, 1
+	for {
+		b, err := r.read(1)
+		if err != nil {
+			return 0, err
+		}
+		data += int(b[0]&0x7F) * shift
+		if b[0]&0x80 != 0 {
+			return data, nil
+		}
+		shift <<= 7
+		data += shift
+		if shift > 1<<28 {
+			return 0, errors.New("broken patch: too large number")
+		}
+	}
+}
+
+// ApplyIPS apply IPS patch. records may extend the ROM, and truncation extension is supported
+func ApplyIPS(rom []byte, patch []byte) ([]byte, error) {
+	r := &patchReader{buf: patch}
+	if header, err := r.read(len(ipsHeader)); err != nil || string(header) != ipsHeader {
+		return nil, errors.New("broken patch: IPS header not found")
+	}
+	out := append([]byte(nil), rom...)
+	for {
+		if bytes.HasPrefix(patch[r.offset:], []byte(ipsFooter)) && len(patch)-r.offset <= len(ipsFooter)+3 {
+			r.offset += len(ipsFooter)
+			break
+		}
+		offset, err := r.readUint(3)
+		if err != nil {
+			return nil, err
+		}
+		size, err := r.readUint(2)
+		if err != nil {
+			return nil, err
+		}
+		var data []byte
+		if size == 0 { // RLE record
+			if size, err = r.readUint(2); err != nil {
+				return nil, err
+			}
+			value, err := r.read(1)
+			if err != nil {
+				return nil, err
+			}
+			data = bytes.Repeat(value, size)
+		} else if data, err = r.read(size); err != nil {
+			return nil, err
+		}
+		if end := offset + len(data); end > len(out) {
+			out = append(out, make([]byte, end-len(out))...)
+		}
+		copy(out[offset:], data)
+	}
+	if r.offset < len(patch) { // truncation extension
+		size, err := r.readUint(3)
+		if err != nil {
+			return nil, err
+		}
+		if size < len(out) {
+			out = out[:size]
+		}
+	}
+	return out, nil
+}
+
+// MakeIPS create IPS patch which converts source into target
+func MakeIPS(source []byte, target []byte) ([]byte, error) {
+	if len(target) > ipsMaxOffset {
+		return nil, fmt.Errorf("target is too large for IPS: %d bytes", len(target))
+	}
+	differ := func(i int) bool {
+		return i >= len(source) || source[i] != target[i]
+	}
+	var patch bytes.Buffer
+	patch.WriteString(ipsHeader)
+	for i := 0; i < len(target); {
+		if !differ(i) {
+			i++
+			continue
+		}
+		start := i
+		// merge short equal runs into record, since record header is 5 bytes
+		for end := i; end < len(target) && end-start < ipsMaxRecordLen-1; end++ {
+			if differ(end) {
+				i = end + 1
+			} else if end-i >= 5 {
+				break
+			}
+		}
+		if start == 0x454F46 { // offset must not be read as footer
+			start--
+		}
+		patch.Write([]byte{byte(start >> 16), byte(start >> 8), byte(start), byte((i - start) >> 8), byte(i - start)})
+		patch.Write(target[start:i])
+	}
+	patch.WriteString(ipsFooter)
+	if len(target) < len(source) {
+		size := len(target)
+		patch.Write([]byte{byte(size >> 16), byte(size >> 8), byte(size)})
+	}
+	return patch.Bytes(), nil
+}
+
+const (
+	bpsSourceRead = iota
+	bpsTargetRead
+	bpsSourceCopy
+	bpsTargetCopy
+)
+
+// ApplyBPS apply BPS patch. checksums of source, target and patch are validated
+func ApplyBPS(rom []byte, patch []byte) ([]byte, error) {
+	if len(patch) < len(bpsHeader)+12 {
+		return nil, errPatchTruncated
+	}
+	if string(patch[:len(bpsHeader)]) != bpsHeader {
+		return nil, errors.New("broken patch: BPS header not found")
+	}
+	footer := patch[len(patch)-12:]
+	sourceCRC := binary.LittleEndian.Uint32(footer[0:])
+	targetCRC := binary.LittleEndian.Uint32(footer[4:])
+	patchCRC := binary.LittleEndian.Uint32(footer[8:])
+	if crc := crc32.ChecksumIEEE(patch[:len(patch)-4]); crc != patchCRC {
+		return nil, fmt.Errorf("broken patch: patch checksum mismatch (expect: %08X, actual: %08X)", patchCRC, crc)
+	}
+	if crc := crc32.ChecksumIEEE(rom); crc != sourceCRC {
+		return nil, fmt.Errorf("patch is not for this ROM: source checksum mismatch (expect: %08X, actual: %08X)",
+			sourceCRC, crc)
+	}
+
+	r := &patchReader{buf: patch[:len(patch)-12], offset: len(bpsHeader)}
+	sourceSize, err := r.readVarint()
+	if err != nil {
+		return nil, err
+	}
+	targetSize, err := r.readVarint()
+	if err != nil {
+		return nil, err
+	}
+	if sourceSize != len(rom) {
+		return nil, fmt.Errorf("patch is not for this ROM: size mismatch (expect: %d, actual: %d)", sourceSize, len(rom))
+	}
+	metadataSize, err := r.readVarint()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.read(metadataSize); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, targetSize)
+	outputOffset, sourceRelative, targetRelative := 0, 0, 0
+	readOffset := func() (int, error) {
+		v, err := r.readVarint()
+		if v&1 != 0 {
+			return -(v >> 1), err
+		}
+		return v >> 1, err
+	}
+	for r.offset < len(r.buf) {
+		command, err := r.readVarint()
+		if err != nil {
+			return nil, err
+		}
+		length := command>>2 + 1
+		if outputOffset+length > targetSize {
+			return nil, errors.New("broken patch: write beyond target size")
+		}
+		switch command & 3 {
+		case bpsSourceRead:
+			if outputOffset+length > len(rom) {
+				return nil, errors.New("broken patch: read beyond source size")
+			}
+			copy(out[outputOffset:], rom[outputOffset:outputOffset+length])
+		case bpsTargetRead:
+			data, err := r.read(length)
+			if err != nil {
+				return nil, err
+			}
+			copy(out[outputOffset:], data)
+		case bpsSourceCopy:
+			offset, err := readOffset()
+			if err != nil {
+				return nil, err
+			}
+			sourceRelative += offset
+			if sourceRelative < 0 || sourceRelative+length > len(rom) {
+				return nil, errors.New("broken patch: read beyond source size")
+			}
+			copy(out[outputOffset:], rom[sourceRelative:sourceRelative+length])
+			sourceRelative += length
+		case bpsTargetCopy:
+			offset, err := readOffset()
+			if err != nil {
+				return nil, err
+			}
+			targetRelative += offset
+			if targetRelative < 0 || targetRelative >= outputOffset {
+				return nil, errors.New("broken patch: read beyond written target")
+			}
+			for i := 0; i < length; i++ { // byte by byte, since source and destination may overlap
+				out[outputOffset+i] = out[targetRelative]
+				targetRelative++
+			}
+		}
+		outputOffset += length
+	}
+	if outputOffset != targetSize {
+		return nil, errors.New("broken patch: target is not fully written")
+	}
+	if crc := crc32.ChecksumIEEE(out); crc != targetCRC {
+		return nil, fmt.Errorf("target checksum mismatch (expect: %08X, actual: %08X)", targetCRC, crc)
+	}
+	return out, nil
+}
+
+func writeVarint(buf *bytes.Buffer, v int) {
+	for {
+		x := byte(v & 0x7F)
+		v >>= 7
+		if v == 0 {
+			buf.WriteByte(0x80 | x)
+			return
+		}
+		buf.WriteByte(x)
+		v--
+	}
+}
+
+// MakeBPS create BPS patch which converts source into target.
+// unchanged bytes are read from source and others are embedded in patch
+func MakeBPS(source []byte, target []byte) []byte {
+	var patch bytes.Buffer
+	patch.WriteString(bpsHeader)
+	writeVarint(&patch, len(source))
+	writeVarint(&patch, len(target))
+	writeVarint(&patch, 0) // no metadata
+
+	same := func(i int) bool {
+		return i < len(source) && source[i] == target[i]
+	}
+	for i := 0; i < len(target); {
+		start := i
+		kind := same(i)
+		for i < len(target) && same(i) == kind {
+			i++
+		}
+		if kind {
+			writeVarint(&patch, (i-start-1)<<2|bpsSourceRead)
+		} else {
+			writeVarint(&patch, (i-start-1)<<2|bpsTargetRead)
+			patch.Write(target[start:i])
+		}
+	}
+
+	footer := make([]byte, 8)
+	binary.LittleEndian.PutUint32(footer[0:], crc32.ChecksumIEEE(source))
+	binary.LittleEndian.PutUint32(footer[4:], crc32.ChecksumIEEE(target))
+	patch.Write(footer)
+	binary.LittleEndian.PutUint32(footer[0:], crc32.ChecksumIEEE(patch.Bytes()))
+	patch.Write(footer[:4])
+	return patch.Bytes()
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"slices"
+	"sort"
+	"strings"
+)
+
+/*
+software post-processing between filtered frame and output image.
+frame is upscaled by pixel-art upscaler, shaded by palette, then effects are applied to each CHIP-8 pixel cell.
+it runs on CPU, so SDL window, screenshot and recording produce the same image
+*/
+
+const (
+	EFFECT_SCANLINES = "scanlines" // darken every other row of output
+	EFFECT_GRID      = "grid"      // gaps between pixels
+	EFFECT_LCD       = "lcd"       // rounded dots
+)
+
+var effectNames = []string{EFFECT_SCANLINES, EFFECT_GRID, EFFECT_LCD}
+
+const defaultUpscaler = "none"
+
+// upscaler scales intensity grid of the size by the factor
+type upscaler struct {
+	factor int
+	scale  func(src []uint8, width int, height int) []uint8
+}
+
+var upscalers = map[string]upscaler{
+	"none":    {factor: 1, scale: func(src []uint8, width int, height int) []uint8 { return src }},
+	"scale2x": {factor: 2, scale: scale2x},
+	"epx":     {factor: 2, scale: scale2x}, // EPX is the same algorithm as Scale2x
+	"scale3x": {factor: 3, scale: scale3x},
+	"scale4x": {factor: 4, scale: func(src []uint8, width int, height int) []uint8 {
+		return scale2x(scale2x(src, width, height), width*2, height*2)
+	}},
+	"hq2x": {factor: 2, scale: hq2x},
+}
+
+func upscalerNames() string {
+	var names []string
+	for name := range upscalers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// validatePostProcess check upscaler and effect names of settings
+func validatePostProcess(upscale string, effects []string) error {
+	if _, ok := upscalers[upscale]; !ok {
+		return fmt.Errorf("unknown upscaler: %s (%s)", upscale, upscalerNames())
+	}
+	for _, effect := range effects {
+		if !slices.Contains(effectNames, effect) {
+			return fmt.Errorf("unknown effect: %s (%s)", effect, strings.Join(effectNames, ", "))
+		}
+	}
+	return nil
+}
+
+// neighbors returns 3x3 pixels around (x, y). pixels outside of the grid are clamped to the edge
+func neighbors(src []uint8, width int, height int, x int, y int) (a, b, c, d, e, f, g, h, i uint8) {
+	at := func(dx int, dy int) uint8 {
+		return src[min(max(y+dy, 0), height-1)*width+min(max(x+dx, 0), width-1)]
+	}
+	return at(-1, -1), at(0, -1), at(1, -1), at(-1, 0), at(0, 0), at(1, 0), at(-1, 1), at(0, 1), at(1, 1)
+}
+
+/*
+Scale2x (EPX). E is expanded into E0 E1 / E2 E3
+
+	A B C
+	D E F
+	G H I
+*/
+func scale2x(src []uint8, width int, height int) []uint8 {
+	dst := make([]uint8, width*height*4)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			_, b, _, d, e, f, _, h, _ := neighbors(src, width, height, x, y)
+			e0, e1, e2, e3 := e, e, e, e
+			if b != h && d != f {
+				if d == b {
+					e0 = d
+				}
+				if b == f {
+					e1 = f
+				}
+				if d == h {
+					e2 = d
+				}
+				if h == f {
+					e3 = f
+				}
+			}
+			i := y*2*width*2 + x*2
+			dst[i], dst[i+1], dst[i+width*2], dst[i+width*2+1] = e0, e1, e2, e3
+		}
+	}
+	return dst
+}
+
+// scale3x expands E into 3x3 pixels E0-E8
+func scale3x(src []uint8, width int, height int) []uint8 {
+	dst := make([]uint8, width*height*9)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			a, b, c, d, e, f, g, h, i := neighbors(src, width, height, x, y)
+			out := [9]uint8{e, e, e, e, e, e, e, e, e}
+			if b != h && d != f {
+				if d == b {
+					out[0] = d
+				}
+				if (d == b && e != c) || (b == f && e != a) {
+					out[1] = b
+				}
+				if b == f {
+					out[2] = f
+				}
+				if (d == b && e != g) || (d == h && e != a) {
+					out[3] = d
+				}
+				if (b == f && e != i) || (h == f && e != c) {
+					out[5] = f
+				}
+				if d == h {
+					out[6] = d
+				}
+				if (d == h && e != i) || (h == f && e != g) {
+					out[7] = h
+				}
+				if h == f {
+					out[8] = f
+				}
+			}
+			for k, v := range out {
+				dst[(y*3+k/3)*width*3+x*3+k%3] = v
+			}
+		}
+	}
+	return dst
+}
+
+// hq2x is hq-style variant of Scale2x. pixels on detected edges are interpolated instead of copied,
+// so diagonals are anti-aliased
+func hq2x(src []uint8, width int, height int) []uint8 {
+	dst := make([]uint8, width*height*4)
+	blend := func(e uint8, n uint8) uint8 {
+		return uint8((int(e)*3 + int(n)*5) / 8)
+	}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			_, b, _, d, e, f, _, h, _ := neighbors(src, width, height, x, y)
+			e0, e1, e2, e3 := e, e, e, e
+			if b != h && d != f {
+				if d == b {
+					e0 = blend(e, d)
+				}
+				if b == f {
+					e1 = blend(e, f)
+				}
+				if d == h {
+					e2 = blend(e, d)
+				}
+				if h == f {
+					e3 = blend(e, f)
+				}
+			}
+			i := y*2*width*2 + x*2
+			dst[i], dst[i+1], dst[i+width*2], dst[i+width*2+1] = e0, e1, e2, e3
+		}
+	}
+	return dst
+}
+
+// Pipeline renders filtered frame into RGBA image
+type Pipeline struct {
+	palette   Palette
+	upscaler  upscaler
+	scanlines bool
+	grid      bool
+	lcd       bool
+	cellSize  int
+	cellMask  []bool // false if the pixel in a cell is gap of grid or outside of LCD dot
+	image     *image.RGBA
+}
+
+func NewPipeline(options DisplayOptions) *Pipeline {
+	p := &Pipeline{palette: options.Palette, upscaler: upscalers[options.Upscale]}
+	if p.upscaler.scale == nil {
+		p.upscaler = upscalers[defaultUpscaler]
+	}
+	p.scanlines = slices.Contains(options.Effects, EFFECT_SCANLINES)
+	p.grid = slices.Contains(options.Effects, EFFECT_GRID)
+	p.lcd = slices.Contains(options.Effects, EFFECT_LCD)
+	return p
+}
+
+// PixelSize returns size of CHIP-8 pixel in output image nearest to the scale.
+// it is 1 if no post-processing is enabled, since output can be scaled by nearest neighbor
+func (p *Pipeline) PixelSize(scale int) int {
+	factor := p.upscaler.factor
+	if factor == 1 && !p.scanlines && !p.grid && !p.lcd {
+		return 1
+	}
+	minSize := factor
+	if p.scanlines {
+		minSize = max(minSize, 2)
+	}
+	if p.grid || p.lcd {
+		minSize = max(minSize, 4)
+	}
+	// round down not to lose scanlines and gaps when output is scaled by nearest neighbor
+	return max(scale/factor*factor, (minSize+factor-1)/factor*factor)
+}
+
+// Render returns image of the frame, where each CHIP-8 pixel is size x size. the image is reused by next call
+func (p *Pipeline) Render(frame *Frame, size int) *image.RGBA {
+	factor := p.upscaler.factor
+	size = max(1, size) / factor * factor
+	size = max(size, factor)
+	width, height := ScreenWidth*size, ScreenHeight*size
+	if p.image == nil || p.image.Rect.Dx() != width || p.image.Rect.Dy() != height {
+		p.image = image.NewRGBA(image.Rect(0, 0, width, height))
+	}
+	if p.cellSize != size {
+		p.cellSize, p.cellMask = size, p.newCellMask(size)
+	}
+	scaled := p.upscaler.scale(frame[:], ScreenWidth, ScreenHeight)
+	scaledWidth, subSize := ScreenWidth*factor, size/factor
+	for y := 0; y < height; y++ {
+		row := p.image.Pix[y*p.image.Stride:]
+		darken := p.scanlines && y%2 == 1
+		for x := 0; x < width; x++ {
+			color := p.palette.Background
+			if p.cellMask[(y%size)*size+x%size] {
+				color = p.palette.Shade(scaled[(y/subSize)*scaledWidth+x/subSize])
+			}
+			if darken {
+				color = Color{R: color.R / 2, G: color.G / 2, B: color.B / 2}
+			}
+			row[x*4], row[x*4+1], row[x*4+2], row[x*4+3] = color.R, color.G, color.B, 0xFF
+		}
+	}
+	return p.image
+}
+
+// newCellMask build mask of grid gaps and LCD dot shape. gap is placed at right and bottom of each cell
+func (p *Pipeline) newCellMask(size int) []bool {
+	mask := make([]bool, size*size)
+	gap := 0
+	if (p.grid || p.lcd) && size >= 3 {
+		gap = max(1, size/8)
+	}
+	inner := size - gap // dot occupies [0, inner)
+	radius := 0.0
+	if p.lcd {
+		radius = float64(inner) / 3
+	}
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if x >= inner || y >= inner {
+				continue
+			}
+			// distance from the nearest corner circle center, if the pixel is in the corner region
+			cx, cy := float64(x)+0.5, float64(y)+0.5
+			dx := max(radius-cx, cx-(float64(inner)-radius), 0)
+			dy := max(radius-cy, cy-(float64(inner)-radius), 0)
+			mask[y*size+x] = dx*dx+dy*dy <= radius*radius
+		}
+	}
+	return mask
+}
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/lzw"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const recordFrameRate = 60
+
+// frameRecorder writes presented frames at 60 fps
+type frameRecorder interface {
+	WriteFrame(img *image.RGBA) error
+	Close() error
+}
+
+// newFrameRecorder create recorder of the format by extension (.gif, .png or .apng, .y4m)
+func newFrameRecorder(path string) (frameRecorder, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext != ".gif" && ext != ".png" && ext != ".apng" && ext != ".y4m" {
+		return nil, fmt.Errorf("unsupported record format: %s (.gif, .png, .apng, .y4m are supported)", path)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	switch ext {
+	case ".gif":
+		return &gifRecorder{file: file, writer: bufio.NewWriter(file)}, nil
+	case ".y4m":
+		return &y4mRecorder{file: file, writer: bufio.NewWriter(file)}, nil
+	default:
+		return &apngRecorder{file: file}, nil
+	}
+}
+
+// pendingFrame merges identical consecutive frames into one frame of longer duration
+type pendingFrame struct {
+	image  *image.RGBA
+	frames int // duration in 1/60 seconds
+}
+
+// extend reports whether the image is the same as pending frame, and extends its duration if so
+func (p *pendingFrame) extend(img *image.RGBA) bool {
+	if p.frames > 0 && bytes.Equal(p.image.Pix, img.Pix) {
+		p.frames++
+		return true
+	}
+	return false
+}
+
+func (p *pendingFrame) replace(img *image.RGBA) {
+	if p.image == nil || p.image.Rect != img.Rect {
+		p.image = image.NewRGBA(img.Rect)
+	}
+	copy(p.image.Pix, img.Pix)
+	p.frames = 1
+}
+
+/*
+animated GIF is written by streaming. each frame has own local color table, since colors depend on
+fade and post-processing. delay is in 1/100 seconds, so it is rounded on timeline of 60 fps
+*/
+type gifRecorder struct {
+	file    *os.File
+	writer  *bufio.Writer
+	pending pendingFrame
+	elapsed int // frames already written
+	started bool
+}
+
+func (g *gifRecorder) WriteFrame(img *image.RGBA) error {
+	if g.pending.extend(img) {
+		return nil
+	}
+	if err := g.flush(); err != nil {
+		return err
+	}
+	g.pending.replace(img)
+	return nil
+}
+
+func (g *gifRecorder) writeHeader(width int, height int) {
+	g.writer.WriteString("GIF89a")
+	_ = binary.Write(g.writer, binary.LittleEndian, [2]uint16{uint16(width), uint16(height)})
+	g.writer.Write([]byte{0x70, 0, 0}) // no global color table, 8 bit color resolution
+	// NETSCAPE2.0 extension to loop forever
+	g.writer.Write([]byte{0x21, 0xFF, 0x0B})
+	g.writer.WriteString("NETSCAPE2.0")
+	g.writer.Write([]byte{0x03, 0x01, 0x00, 0x00, 0x00})
+}
+
+func (g *gifRecorder) flush() error {
+	img := g.pending.image
+	if g.pending.frames == 0 {
+		return nil
+	}
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	if !g.started {
+		g.writeHeader(width, height)
+		g.started = true
+	}
+	delay := (g.elapsed+g.pending.frames)*100/recordFrameRate - g.elapsed*100/recordFrameRate
+	g.elapsed += g.pending.frames
+	g.pending.frames = 0
+
+	colors, indices := gifPalette(img)
+	// graphic control extension. disposal method 1 (do not dispose)
+	g.writer.Write([]byte{0x21, 0xF9, 0x04, 0x04, byte(delay), byte(delay >> 8), 0x00, 0x00})
+	g.writer.WriteByte(0x2C)
+	_ = binary.Write(g.writer, binary.LittleEndian, [4]uint16{0, 0, uint16(width), uint16(height)})
+	g.writer.WriteByte(0x87) // local color table of 256 entries
+	for i := 0; i < 256; i++ {
+		var r, gr, b uint8
+		if i < len(colors) {
+			r, gr, b = colors[i].R, colors[i].G, colors[i].B
+		}
+		g.writer.Write([]byte{r, gr, b})
+	}
+	g.writer.WriteByte(8) // LZW minimum code size
+	blocks := &gifBlockWriter{writer: g.writer}
+	compressor := lzw.NewWriter(blocks, lzw.LSB, 8)
+	if _, err := compressor.Write(indices); err != nil {
+		return err
+	}
+	if err := compressor.Close(); err != nil {
+		return err
+	}
+	if err := blocks.close(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// gifPalette returns up to 256 colors and color index of each pixel. extra colors are mapped to the nearest
+func gifPalette(img *image.RGBA) ([]color.RGBA, []byte) {
+	var colors []color.RGBA
+	index := make(map[color.RGBA]byte)
+	indices := make([]byte, img.Rect.Dx()*img.Rect.Dy())
+	var extra []int
+	for i := range indices {
+		c := color.RGBA{R: img.Pix[i*4], G: img.Pix[i*4+1], B: img.Pix[i*4+2], A: 0xFF}
+		if n, ok := index[c]; ok {
+			indices[i] = n
+		} else if len(colors) < 256 {
+			index[c] = byte(len(colors))
+			indices[i] = byte(len(colors))
+			colors = append(colors, c)
+		} else {
+			extra = append(extra, i)
+		}
+	}
+	if len(extra) > 0 {
+		palette := make(color.Palette, len(colors))
+		for i, c := range colors {
+			palette[i] = c
+		}
+		for _, i := range extra {
+			indices[i] = byte(palette.Index(color.RGBA{R: img.Pix[i*4], G: img.Pix[i*4+1], B: img.Pix[i*4+2], A: 0xFF}))
+		}
+	}
+	return colors, indices
+}
+
+// gifBlockWriter splits data into sub-blocks of up to 255 bytes
+type gifBlockWriter struct {
+	writer *bufio.Writer
+	buf    []byte
+}
+
+func (b *gifBlockWriter) Write(data []byte) (int, error) {
+	b.buf = append(b.buf, data...)
+	for len(b.buf) >= 255 {
+		b.writer.WriteByte(255)
+		if _, err := b.writer.Write(b.buf[:255]); err != nil {
+			return 0, err
+		}
+		b.buf = b.buf[255:]
+	}
+	return len(data), nil
+}
+
+func (b *gifBlockWriter) close() error {
+	if len(b.buf) > 0 {
+		b.writer.WriteByte(byte(len(b.buf)))
+		b.writer.Write(b.buf)
+	}
+	return b.writer.WriteByte(0) // block terminator
+}
+
+func (g *gifRecorder) Close() error {
+	err := g.flush()
+	if g.started {
+		g.writer.WriteByte(0x3B) // trailer
+	}
+	if flushErr := g.writer.Flush(); err == nil {
+		err = flushErr
+	}
+	if closeErr := g.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+/*
+APNG is written from PNG encoded by image/png. IHDR and IDAT of the first frame are copied,
+and IDAT of following frames are written as fdAT. number of frames in acTL is patched at close
+*/
+type apngRecorder struct {
+	file     *os.File
+	pending  pendingFrame
+	offset   int64 // written bytes
+	actl     int64 // offset of acTL chunk
+	sequence uint32
+	frames   uint32
+}
+
+func (a *apngRecorder) WriteFrame(img *image.RGBA) error {
+	if a.pending.extend(img) {
+		return nil
+	}
+	if err := a.flush(); err != nil {
+		return err
+	}
+	a.pending.replace(img)
+	return nil
+}
+
+func (a *apngRecorder) writeChunk(chunkType string, data []byte) error {
+	buf := make([]byte, 0, len(data)+12)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(data)))
+	buf = append(buf, chunkType...)
+	buf = append(buf, data...)
+	buf = binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf[4:]))
+	n, err := a.file.Write(buf)
+	a.offset += int64(n)
+	return err
+}
+
+// pngChunks returns chunks of PNG data in order
+func pngChunks(data []byte) (types []string, chunks [][]byte) {
+	for i := 8; i+12 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		types = append(types, string(data[i+4:i+8]))
+		chunks = append(chunks, data[i+8:i+8+length])
+		i += length + 12
+	}
+	return types, chunks
+}
+
+func (a *apngRecorder) flush() error {
+	img := a.pending.image
+	if a.pending.frames == 0 {
+		return nil
+	}
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, img); err != nil {
+		return err
+	}
+	types, chunks := pngChunks(encoded.Bytes())
+	if a.frames == 0 {
+		n, err := a.file.Write(encoded.Bytes()[:8]) // signature
+		a.offset += int64(n)
+		if err != nil {
+			return err
+		}
+		if err := a.writeChunk(types[0], chunks[0]); err != nil { // IHDR
+			return err
+		}
+		a.actl = a.offset
+		if err := a.writeChunk("acTL", make([]byte, 8)); err != nil { // number of frames and plays (0 is infinite)
+			return err
+		}
+	}
+	fctl := binary.BigEndian.AppendUint32(nil, a.sequence)
+	fctl = binary.BigEndian.AppendUint32(fctl, uint32(img.Rect.Dx()))
+	fctl = binary.BigEndian.AppendUint32(fctl, uint32(img.Rect.Dy()))
+	fctl = binary.BigEndian.AppendUint64(fctl, 0) // x and y offset
+	fctl = binary.BigEndian.AppendUint16(fctl, uint16(a.pending.frames))
+	fctl = binary.BigEndian.AppendUint16(fctl, recordFrameRate)
+	fctl = append(fctl, 0, 0) // dispose and blend op
+	a.sequence++
+	if err := a.writeChunk("fcTL", fctl); err != nil {
+		return err
+	}
+	for i, chunkType := range types {
+		if chunkType != "IDAT" {
+			continue
+		}
+		if a.frames == 0 {
+			if err := a.writeChunk("IDAT", chunks[i]); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := a.writeChunk("fdAT", append(binary.BigEndian.AppendUint32(nil, a.sequence), chunks[i]...)); err != nil {
+			return err
+		}
+		a.sequence++
+	}
+	a.frames++
+	a.pending.frames = 0
+	return nil
+}
+
+func (a *apngRecorder) Close() error {
+	err := a.flush()
+	if a.frames > 0 && err == nil {
+		if err = a.writeChunk("IEND", nil); err == nil {
+			actl := binary.BigEndian.AppendUint32(nil, 8)
+			actl = append(actl, "acTL"...)
+			actl = binary.BigEndian.AppendUint32(actl, a.frames)
+			actl = binary.BigEndian.AppendUint32(actl, 0)
+			actl = binary.BigEndian.AppendUint32(actl, crc32.ChecksumIEEE(actl[4:]))
+			_, err = a.file.WriteAt(actl, a.actl)
+		}
+	}
+	if closeErr := a.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// y4mRecorder writes raw YUV 4:4:4 frames of full range, which can be converted by ffmpeg
+type y4mRecorder struct {
+	file    *os.File
+	writer  *bufio.Writer
+	started bool
+	planes  []byte
+}
+
+func (y *y4mRecorder) WriteFrame(img *image.RGBA) error {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	if !y.started {
+		_, _ = fmt.Fprintf(y.writer, "YUV4MPEG2 W%d H%d F%d:1 Ip A1:1 C444 XCOLORRANGE=FULL\n", width, height, recordFrameRate)
+		y.started = true
+		y.planes = make([]byte, width*height*3)
+	}
+	size := width * height
+	for i := 0; i < size; i++ {
+		y.planes[i], y.planes[size+i], y.planes[size*2+i] = color.RGBToYCbCr(img.Pix[i*4], img.Pix[i*4+1], img.Pix[i*4+2])
+	}
+	y.writer.WriteString("FRAME\n")
+	_, err := y.writer.Write(y.planes)
+	return err
+}
+
+func (y *y4mRecorder) Close() error {
+	err := y.writer.Flush()
+	if closeErr := y.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+const (
+	wavSampleRate    = 44100
+	wavToneFrequency = 440
+	wavAmplitude     = 8000
+)
+
+// wavRecorder writes square wave while sound timer is active, in sync with recorded frames
+type wavRecorder struct {
+	file    *os.File
+	writer  *bufio.Writer
+	samples int
+}
+
+func newWavRecorder(path string) (*wavRecorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := &wavRecorder{file: file, writer: bufio.NewWriter(file)}
+	w.writeHeader(w.writer)
+	return w, nil
+}
+
+// writeHeader write RIFF header of 16 bit mono PCM. sizes are patched at close
+func (w *wavRecorder) writeHeader(writer io.Writer) {
+	dataSize := uint32(w.samples * 2)
+	header := []byte("RIFF")
+	header = binary.LittleEndian.AppendUint32(header, 36+dataSize)
+	header = append(header, "WAVEfmt "...)
+	header = binary.LittleEndian.AppendUint32(header, 16)
+	header = binary.LittleEndian.AppendUint16(header, 1) // PCM
+	header = binary.LittleEndian.AppendUint16(header, 1) // mono
+	header = binary.LittleEndian.AppendUint32(header, wavSampleRate)
+	header = binary.LittleEndian.AppendUint32(header, wavSampleRate*2)
+	header = binary.LittleEndian.AppendUint16(header, 2)
+	header = binary.LittleEndian.AppendUint16(header, 16)
+	header = append(header, "data"...)
+	header = binary.LittleEndian.AppendUint32(header, dataSize)
+	_, _ = writer.Write(header)
+}
+
+// WriteFrame write samples of a frame. phase of tone continues across frames
+func (w *wavRecorder) WriteFrame(sound bool) error {
+	end := (w.samples/(wavSampleRate/recordFrameRate) + 1) * (wavSampleRate / recordFrameRate)
+	for ; w.samples < end; w.samples++ {
+		var sample int16
+		if sound {
+			sample = wavAmplitude
+			if w.samples*wavToneFrequency*2/wavSampleRate%2 == 1 {
+				sample = -wavAmplitude
+			}
+		}
+		if err := binary.Write(w.writer, binary.LittleEndian, sample); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *wavRecorder) Close() error {
+	err := w.writer.Flush()
+	if err == nil {
+		var header bytes.Buffer
+		w.writeHeader(&header)
+		_, err = w.file.WriteAt(header.Bytes(), 0)
+	}
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
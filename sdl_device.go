@@ -3,77 +3,217 @@ package main
 import (
 	"fmt"
 	"github.com/veandco/go-sdl2/sdl"
+	"image"
+	"math"
+	"unsafe"
 )
 
-/*
-original keycode
-1 2 3 C
-4 5 6 D
-7 8 9 E
-A 0 B F
-
-physical keycode
-1 2 3 4
-q w e r
-a s d f
-z x c v
-*/
-var keyMap = map[sdl.Keycode]uint8{
-	sdl.K_1: 0x01,
-	sdl.K_2: 0x02,
-	sdl.K_3: 0x03,
-	sdl.K_4: 0x0C,
-	sdl.K_q: 0x04,
-	sdl.K_w: 0x05,
-	sdl.K_e: 0x06,
-	sdl.K_r: 0x0d,
-	sdl.K_a: 0x07,
-	sdl.K_s: 0x08,
-	sdl.K_d: 0x09,
-	sdl.K_f: 0x0E,
-	sdl.K_z: 0x0A,
-	sdl.K_x: 0x00,
-	sdl.K_c: 0x0B,
-	sdl.K_v: 0x0F,
+var sdlKeyNames = map[string]sdl.Keycode{
+	"escape":    sdl.K_ESCAPE,
+	"space":     sdl.K_SPACE,
+	"enter":     sdl.K_RETURN,
+	"tab":       sdl.K_TAB,
+	"backspace": sdl.K_BACKSPACE,
+	"up":        sdl.K_UP,
+	"down":      sdl.K_DOWN,
+	"left":      sdl.K_LEFT,
+	"right":     sdl.K_RIGHT,
+	"kp0":       sdl.K_KP_0,
+	"kp1":       sdl.K_KP_1,
+	"kp2":       sdl.K_KP_2,
+	"kp3":       sdl.K_KP_3,
+	"kp4":       sdl.K_KP_4,
+	"kp5":       sdl.K_KP_5,
+	"kp6":       sdl.K_KP_6,
+	"kp7":       sdl.K_KP_7,
+	"kp8":       sdl.K_KP_8,
+	"kp9":       sdl.K_KP_9,
+	"kp/":       sdl.K_KP_DIVIDE,
+	"kp*":       sdl.K_KP_MULTIPLY,
+	"kp-":       sdl.K_KP_MINUS,
+	"kp+":       sdl.K_KP_PLUS,
+	"kp.":       sdl.K_KP_PERIOD,
+	"kpenter":   sdl.K_KP_ENTER,
+	"f1":        sdl.K_F1,
+	"f2":        sdl.K_F2,
+	"f3":        sdl.K_F3,
+	"f4":        sdl.K_F4,
+	"f5":        sdl.K_F5,
+	"f6":        sdl.K_F6,
+	"f7":        sdl.K_F7,
+	"f8":        sdl.K_F8,
+	"f9":        sdl.K_F9,
+	"f10":       sdl.K_F10,
+	"f11":       sdl.K_F11,
+	"f12":       sdl.K_F12,
+}
+
+// sdlKeycode convert key name of Keymap. SDL treats single character name as its keycode
+func sdlKeycode(name string) sdl.Keycode {
+	if keycode, ok := sdlKeyNames[name]; ok {
+		return keycode
+	}
+	return sdl.GetKeyFromName(name)
 }
 
 type SDLDevice struct {
-	window   *sdl.Window
-	renderer *sdl.Renderer
+	window      *sdl.Window
+	renderer    *sdl.Renderer
+	texture     *sdl.Texture
+	display     DisplayOptions
+	filter      *DisplayFilter
+	pipeline    *Pipeline
+	frame       *Frame // last presented frame
+	onRecord    func()
+	ticker      frameTicker
+	pacer       instructionPacer
+	keyMap      map[sdl.Keycode]uint8
+	quitKey     sdl.Keycode
+	gamepad     *GamepadMap
+	controllers map[sdl.JoystickID]*sdl.GameController
+	buttons     map[string]bool // pressed state of gamepad buttons and left stick directions
 }
 
-const scale = 8
+func NewSDLDevice(options FrontendOptions) *SDLDevice {
+	device := &SDLDevice{
+		keyMap:      make(map[sdl.Keycode]uint8),
+		quitKey:     sdlKeycode(options.Keymap.QuitKey()),
+		gamepad:     options.Gamepad,
+		controllers: make(map[sdl.JoystickID]*sdl.GameController),
+		buttons:     make(map[string]bool),
+		display:     options.Display,
+		filter:      NewDisplayFilter(options.Display),
+		pipeline:    NewPipeline(options.Display),
+		pacer:       instructionPacer{rate: defaultInstructionRate},
+	}
+	for name, key := range options.Keymap.Bindings() {
+		device.keyMap[sdlKeycode(name)] = key
+	}
+	return device
+}
 
+// Draw render filtered and post-processed frame into texture at 60 fps, then copy it letterboxed into the window
 func (sdlDevice *SDLDevice) Draw(screen *Screen) error {
-	if err := sdlDevice.renderer.SetDrawColor(0, 0, 0, 1); err != nil {
+	sdlDevice.pacer.wait()
+	if !sdlDevice.ticker.due() {
+		return nil
+	}
+	x, y, w, h, err := sdlDevice.layout()
+	if err != nil {
 		return err
 	}
-	if err := sdlDevice.renderer.Clear(); err != nil {
+	sdlDevice.frame = sdlDevice.filter.Apply(screen)
+	img := sdlDevice.pipeline.Render(sdlDevice.frame, sdlDevice.pipeline.PixelSize(h/ScreenHeight))
+	if err := sdlDevice.updateTexture(img); err != nil {
 		return err
 	}
-	if err := sdlDevice.renderer.SetDrawColor(255, 255, 255, 1); err != nil {
+	if err := sdlDevice.renderer.SetDrawColor(0, 0, 0, 255); err != nil {
 		return err
 	}
-	for height := 0; height < ScreenHeight; height++ {
-		for width := 0; width < ScreenWidth; width++ {
-			pixel := screen[ScreenWidth*height+width]
-			if pixel != 0 {
-				err := sdlDevice.renderer.FillRect(&sdl.Rect{
-					X: int32(width) * scale,
-					Y: int32(height) * scale,
-					W: scale,
-					H: scale,
-				})
-				if err != nil {
-					return err
-				}
-			}
-		}
+	if err := sdlDevice.renderer.Clear(); err != nil {
+		return err
+	}
+	if err := sdlDevice.renderer.Copy(sdlDevice.texture, nil, &sdl.Rect{X: int32(x), Y: int32(y), W: int32(w), H: int32(h)}); err != nil {
+		return err
 	}
 	sdlDevice.renderer.Present()
 	return nil
 }
 
+// updateTexture upload the image. texture is recreated when size of post-processed image is changed
+func (sdlDevice *SDLDevice) updateTexture(img *image.RGBA) error {
+	width, height := int32(img.Rect.Dx()), int32(img.Rect.Dy())
+	if sdlDevice.texture != nil {
+		if _, _, w, h, err := sdlDevice.texture.Query(); err != nil || w != width || h != height {
+			_ = sdlDevice.texture.Destroy()
+			sdlDevice.texture = nil
+		}
+	}
+	if sdlDevice.texture == nil {
+		texture, err := sdlDevice.renderer.CreateTexture(uint32(sdl.PIXELFORMAT_RGBA32), sdl.TEXTUREACCESS_STREAMING, width, height)
+		if err != nil {
+			return err
+		}
+		sdlDevice.texture = texture
+	}
+	return sdlDevice.texture.Update(nil, unsafe.Pointer(&img.Pix[0]), img.Stride)
+}
+
+// layout returns rectangle of the screen in output pixels. integer scale is in window points,
+// so it is multiplied by pixel density of HiDPI display
+func (sdlDevice *SDLDevice) layout() (int, int, int, int, error) {
+	outputWidth, outputHeight, err := sdlDevice.renderer.GetOutputSize()
+	if err != nil {
+		return 0, 0, 0, 0, err
+	}
+	scale := sdlDevice.display.Scale
+	if windowWidth, _ := sdlDevice.window.GetSize(); scale > 0 && windowWidth > 0 {
+		density := float64(outputWidth) / float64(windowWidth)
+		scale = max(1, int(math.Round(float64(scale)*density)))
+	}
+	x, y, w, h := letterbox(int(outputWidth), int(outputHeight), scale)
+	return x, y, w, h, nil
+}
+
+func (sdlDevice *SDLDevice) toggleFullscreen() {
+	var flags uint32
+	if sdlDevice.window.GetFlags()&sdl.WINDOW_FULLSCREEN == 0 {
+		flags = sdl.WINDOW_FULLSCREEN_DESKTOP
+	}
+	if err := sdlDevice.window.SetFullscreen(flags); err != nil {
+		fmt.Printf("fullscreen error: %v\n", err)
+	}
+}
+
+// screenshot write the last frame as PNG at the current scale
+func (sdlDevice *SDLDevice) screenshot() {
+	if sdlDevice.frame == nil {
+		return
+	}
+	scale := sdlDevice.display.Scale
+	if scale == 0 {
+		_, _, _, h, err := sdlDevice.layout()
+		if err != nil {
+			fmt.Printf("screenshot error: %v\n", err)
+			return
+		}
+		scale = max(1, h/ScreenHeight)
+	}
+	// separate pipeline not to resize image of the window
+	if err := writeScreenshot(screenshotPath(), NewPipeline(sdlDevice.display), sdlDevice.frame, scale); err != nil {
+		fmt.Println(err)
+	}
+}
+
+func (sdlDevice *SDLDevice) OnToggleRecording(toggle func()) {
+	sdlDevice.onRecord = toggle
+}
+
+// hotkey handles F10 (recording), F11 or Alt+Enter (fullscreen) and F12 (screenshot) unless it is bound by keymap
+func (sdlDevice *SDLDevice) hotkey(event *sdl.KeyboardEvent) bool {
+	keysym := event.Keysym
+	if _, ok := sdlDevice.keyMap[keysym.Sym]; ok {
+		return false
+	}
+	switch {
+	case keysym.Sym == sdl.K_F11 || (keysym.Sym == sdl.K_RETURN && keysym.Mod&sdl.KMOD_ALT != 0):
+		if event.Repeat == 0 {
+			sdlDevice.toggleFullscreen()
+		}
+	case keysym.Sym == sdl.K_F12:
+		if event.Repeat == 0 {
+			sdlDevice.screenshot()
+		}
+	case keysym.Sym == sdl.K_F10 && sdlDevice.onRecord != nil:
+		if event.Repeat == 0 {
+			sdlDevice.onRecord()
+		}
+	default:
+		return false
+	}
+	return true
+}
+
 func (sdlDevice *SDLDevice) PollKey(keypad *Keypad) bool {
 	if event := sdl.PollEvent(); event != nil {
 		switch event := event.(type) {
@@ -83,42 +223,141 @@ func (sdlDevice *SDLDevice) PollKey(keypad *Keypad) bool {
 		case *sdl.KeyboardEvent:
 			switch event.Type {
 			case sdl.KEYDOWN:
-				if keycode, ok := keyMap[event.Keysym.Sym]; ok {
+				if sdlDevice.hotkey(event) {
+					return true
+				}
+				if keycode, ok := sdlDevice.keyMap[event.Keysym.Sym]; ok {
 					fmt.Printf("keydown: %s => %x\n", sdl.GetKeyName(event.Keysym.Sym), keycode)
 					if keypad.IsEmpty() { // only allow one key
 						keypad.Press(keycode)
 					}
 				}
-				if event.Keysym.Sym == sdl.K_ESCAPE {
+				if event.Keysym.Sym == sdlDevice.quitKey {
 					fmt.Printf("Quit: %s\n", sdl.GetKeyName(event.Keysym.Sym))
 					return false
 				}
 			case sdl.KEYUP:
-				if keycode, ok := keyMap[event.Keysym.Sym]; ok {
+				if keycode, ok := sdlDevice.keyMap[event.Keysym.Sym]; ok {
 					fmt.Printf("keyup: %s => %x\n", sdl.GetKeyName(event.Keysym.Sym), keycode)
 					keypad.Release(keycode)
 				}
 			}
+		case *sdl.ControllerDeviceEvent:
+			sdlDevice.plugController(event)
+		case *sdl.ControllerButtonEvent:
+			if int(event.Button) < len(gamepadButtonNames) {
+				button := gamepadButtonNames[event.Button]
+				sdlDevice.pressButton(keypad, button, button, event.Type == sdl.CONTROLLERBUTTONDOWN)
+			}
+		case *sdl.ControllerAxisEvent: // left stick works as D-pad
+			var negative, positive string
+			switch event.Axis {
+			case sdl.CONTROLLER_AXIS_LEFTX:
+				negative, positive = "dpleft", "dpright"
+			case sdl.CONTROLLER_AXIS_LEFTY:
+				negative, positive = "dpup", "dpdown"
+			}
+			if negative != "" {
+				sdlDevice.pressButton(keypad, stickPrefix+negative, negative, event.Value < -stickThreshold)
+				sdlDevice.pressButton(keypad, stickPrefix+positive, positive, event.Value > stickThreshold)
+			}
 		}
 	}
 	return true
 }
 
+const (
+	stickThreshold = 16000
+	stickPrefix    = "stick:" // pressed state of left stick is kept apart from D-pad
+)
+
+// plugController open or close controllers. SDL sends added events of already connected controllers at startup
+func (sdlDevice *SDLDevice) plugController(event *sdl.ControllerDeviceEvent) {
+	switch event.Type {
+	case sdl.CONTROLLERDEVICEADDED: // Which is device index
+		controller := sdl.GameControllerOpen(int(event.Which))
+		if controller == nil {
+			return
+		}
+		sdlDevice.controllers[controller.Joystick().InstanceID()] = controller
+		fmt.Printf("gamepad connected: %s\n", controller.Name())
+	case sdl.CONTROLLERDEVICEREMOVED: // Which is instance id
+		if controller, ok := sdlDevice.controllers[event.Which]; ok {
+			fmt.Printf("gamepad disconnected: %s\n", controller.Name())
+			controller.Close()
+			delete(sdlDevice.controllers, event.Which)
+		}
+	}
+}
+
+// pressButton update pressed state of the source, which is the button itself or left stick direction.
+// the key is held while either the D-pad button or the stick is active
+func (sdlDevice *SDLDevice) pressButton(keypad *Keypad, source string, button string, pressed bool) {
+	if sdlDevice.buttons[source] == pressed {
+		return
+	}
+	wasActive := sdlDevice.buttons[button] || sdlDevice.buttons[stickPrefix+button]
+	sdlDevice.buttons[source] = pressed
+	active := sdlDevice.buttons[button] || sdlDevice.buttons[stickPrefix+button]
+	if active == wasActive {
+		return
+	}
+	keycode, ok := sdlDevice.gamepad.Lookup(button)
+	if !ok {
+		return
+	}
+	if active {
+		keypad.Press(keycode)
+	} else {
+		keypad.Release(keycode)
+	}
+}
+
 func (sdlDevice *SDLDevice) Setup() error {
 	err := sdl.Init(sdl.INIT_EVERYTHING)
 	if err != nil {
 		return err
 	}
-	window, renderer, err := sdl.CreateWindowAndRenderer(640, 480, sdl.WINDOW_OPENGL)
+	sdl.SetHint(sdl.HINT_RENDER_SCALE_QUALITY, "nearest") // keep pixels sharp
+	scale := sdlDevice.display.Scale
+	if scale == 0 {
+		scale = defaultWindowScale
+	}
+	var flags uint32 = sdl.WINDOW_RESIZABLE | sdl.WINDOW_ALLOW_HIGHDPI
+	if sdlDevice.display.Fullscreen {
+		flags |= sdl.WINDOW_FULLSCREEN_DESKTOP
+	}
+	window, err := sdl.CreateWindow("octochip", sdl.WINDOWPOS_CENTERED, sdl.WINDOWPOS_CENTERED,
+		int32(ScreenWidth*scale), int32(ScreenHeight*scale), flags)
 	if err != nil {
 		return err
 	}
-	window.SetTitle("octochip")
 	sdlDevice.window = window
+	window.SetMinimumSize(ScreenWidth, ScreenHeight)
+	renderer, err := sdl.CreateRenderer(window, -1, sdl.RENDERER_ACCELERATED)
+	if err != nil {
+		return err
+	}
 	sdlDevice.renderer = renderer
 	return nil
 }
 
 func (sdlDevice *SDLDevice) Teardown() {
+	for id, controller := range sdlDevice.controllers {
+		controller.Close()
+		delete(sdlDevice.controllers, id)
+	}
+	if sdlDevice.texture != nil {
+		_ = sdlDevice.texture.Destroy()
+		sdlDevice.texture = nil
+	}
+	if sdlDevice.renderer != nil {
+		_ = sdlDevice.renderer.Destroy()
+		sdlDevice.renderer = nil
+	}
+	if sdlDevice.window != nil {
+		_ = sdlDevice.window.Destroy()
+		sdlDevice.window = nil
+	}
 	sdl.Quit()
 }
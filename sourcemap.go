@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SourceMapEntry maps ROM address range [Address, Address+Size) to source line
+type SourceMapEntry struct {
+	Address uint16 `json:"address"`
+	Size    int    `json:"size"`
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Source  string `json:"source"`
+}
+
+func (e *SourceMapEntry) String() string {
+	return fmt.Sprintf("%s:%d", e.File, e.Line)
+}
+
+type SourceMap struct {
+	Entries []SourceMapEntry `json:"entries"` // sorted by address
+}
+
+func LoadSourceMap(reader io.Reader) (*SourceMap, error) {
+	sourceMap := &SourceMap{}
+	if err := json.NewDecoder(reader).Decode(sourceMap); err != nil {
+		return nil, fmt.Errorf("broken source map: %v", err)
+	}
+	sort.Slice(sourceMap.Entries, func(i, j int) bool {
+		return sourceMap.Entries[i].Address < sourceMap.Entries[j].Address
+	})
+	return sourceMap, nil
+}
+
+func (m *SourceMap) WriteJSON(writer io.Writer) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(m)
+}
+
+// Lookup returns entry covering the address. nil-safe
+func (m *SourceMap) Lookup(addr uint16) *SourceMapEntry {
+	if m == nil {
+		return nil
+	}
+	i := sort.Search(len(m.Entries), func(i int) bool {
+		return int(m.Entries[i].Address)+m.Entries[i].Size > int(addr)
+	})
+	if i < len(m.Entries) && m.Entries[i].Address <= addr {
+		return &m.Entries[i]
+	}
+	return nil
+}
+
+// Resolve resolves location into address. location is address or FILE:LINE.
+// FILE matches full path, path suffix or base name of source file
+func (m *SourceMap) Resolve(location string) (uint16, error) {
+	index := strings.LastIndexByte(location, ':')
+	if index == -1 {
+		v, err := strconv.ParseUint(location, 0, 16)
+		if err != nil || v >= Chip8RAMSize {
+			return 0, fmt.Errorf("invalid address: %s", location)
+		}
+		return uint16(v), nil
+	}
+	if m == nil {
+		return 0, fmt.Errorf("source location requires source map: %s", location)
+	}
+	file := filepath.ToSlash(location[:index])
+	line, err := strconv.Atoi(location[index+1:])
+	if err != nil {
+		return 0, fmt.Errorf("invalid line number: %s", location)
+	}
+	var found *SourceMapEntry
+	for i := range m.Entries { // first instruction at or after the line
+		entry := &m.Entries[i]
+		path := filepath.ToSlash(entry.File)
+		if path != file && !strings.HasSuffix(path, "/"+file) {
+			continue
+		}
+		if entry.Line >= line && (found == nil || entry.Line < found.Line ||
+			(entry.Line == found.Line && entry.Address < found.Address)) {
+			found = entry
+		}
+	}
+	if found == nil {
+		return 0, fmt.Errorf("no code at %s", location)
+	}
+	return found.Address, nil
+}
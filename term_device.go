@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	termKeyHold = 150 * time.Millisecond // terminal has no key release event, so key is released after this
+	termCtrlC   = 0x03
+	termEscape  = 0x1B
+)
+
+type termMode int
+
+const (
+	TERM_HALF_BLOCK termMode = iota // 64x16 characters of upper half block
+	TERM_BRAILLE                    // 32x8 characters of braille pattern
+)
+
+// TermDevice renders screen in terminal with ANSI colors and reads keys in raw mode
+type TermDevice struct {
+	mode      termMode
+	keymap    *Keymap
+	palette   Palette
+	sttyState string
+	input     chan []byte
+	releaseAt [KeyNum]time.Time
+	filter    *DisplayFilter
+	ticker    frameTicker
+	last      Frame
+	drawn     bool
+	active    bool
+	pacer     instructionPacer
+}
+
+// NewTermDevice create terminal frontend. mode is half (default) or braille
+func NewTermDevice(mode string, options FrontendOptions) (*TermDevice, error) {
+	device := &TermDevice{
+		keymap:  options.Keymap,
+		palette: options.Display.Palette,
+		filter:  NewDisplayFilter(options.Display),
+		input:   make(chan []byte, 64),
+		pacer:   instructionPacer{rate: defaultInstructionRate},
+	}
+	switch mode {
+	case "", "half":
+		device.mode = TERM_HALF_BLOCK
+	case "braille":
+		device.mode = TERM_BRAILLE
+	default:
+		return nil, fmt.Errorf("unknown terminal mode: %s (half, braille are supported)", mode)
+	}
+	return device, nil
+}
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+func (t *TermDevice) Setup() error {
+	state, err := stty("-g")
+	if err != nil {
+		return errors.New("terminal frontend requires terminal as stdin")
+	}
+	if _, err := stty("raw", "-echo"); err != nil {
+		return err
+	}
+	t.sttyState = state
+	t.active = true
+	// alternate screen, hide cursor and clear
+	_, _ = os.Stdout.WriteString("\x1b[?1049h\x1b[?25l\x1b[2J")
+	go func() {
+		for {
+			buf := make([]byte, 64)
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				close(t.input)
+				return
+			}
+			t.input <- buf[:n]
+		}
+	}()
+	return nil
+}
+
+// Teardown restore terminal. it can be called more than once
+func (t *TermDevice) Teardown() {
+	if !t.active {
+		return
+	}
+	t.active = false
+	_, _ = os.Stdout.WriteString("\x1b[0m\x1b[?25h\x1b[?1049l")
+	_, _ = stty(t.sttyState)
+}
+
+func (t *TermDevice) PollKey(keypad *Keypad) bool {
+	now := time.Now()
+	for key := uint8(0); key < KeyNum; key++ {
+		if keypad.IsPressed(key) && now.After(t.releaseAt[key]) {
+			keypad.Release(key)
+		}
+	}
+	for {
+		select {
+		case buf, ok := <-t.input:
+			if !ok {
+				return false
+			}
+			if bytes.IndexByte(buf, termCtrlC) != -1 {
+				return false
+			}
+			for _, name := range termKeyNames(buf) {
+				if t.keymap.IsQuit(name) {
+					return false
+				}
+				key, ok := t.lookup(name)
+				if !ok {
+					continue
+				}
+				if keypad.IsEmpty() || keypad.IsPressed(key) { // only allow one key
+					keypad.Press(key)
+					t.releaseAt[key] = now.Add(termKeyHold)
+				}
+			}
+		default:
+			return true
+		}
+	}
+}
+
+var termArrowKeys = map[byte]string{'A': "up", 'B': "down", 'C': "right", 'D': "left"}
+
+// termKeyNames convert terminal input into key names of Keymap
+func termKeyNames(buf []byte) []string {
+	if len(buf) == 1 && buf[0] == termEscape {
+		return []string{"escape"}
+	}
+	var names []string
+	for len(buf) > 0 {
+		if buf[0] == termEscape {
+			if len(buf) < 3 || (buf[1] != '[' && buf[1] != 'O') {
+				break // ignore unknown escape sequence
+			}
+			if name, ok := termArrowKeys[buf[2]]; ok {
+				names = append(names, name)
+			}
+			buf = buf[3:]
+			continue
+		}
+		r, size := utf8.DecodeRune(buf)
+		buf = buf[size:]
+		switch {
+		case r == ' ':
+			names = append(names, "space")
+		case r == '\r' || r == '\n':
+			names = append(names, "enter")
+		case r == '\t':
+			names = append(names, "tab")
+		case r == 0x7F || r == '\b':
+			names = append(names, "backspace")
+		case r >= 0x20:
+			names = append(names, string(unicode.ToLower(r)))
+		}
+	}
+	return names
+}
+
+// lookup CHIP-8 key. numeric keypad can not be distinguished in terminal, so they are also tried
+func (t *TermDevice) lookup(name string) (uint8, bool) {
+	if key, ok := t.keymap.Lookup(name); ok {
+		return key, true
+	}
+	if name == "enter" {
+		return t.keymap.Lookup("kpenter")
+	}
+	if len(name) == 1 && strings.Contains("0123456789/*-+.", name) {
+		return t.keymap.Lookup("kp" + name)
+	}
+	return 0, false
+}
+
+func (t *TermDevice) Draw(screen *Screen) error {
+	t.pacer.wait()
+	if !t.ticker.due() {
+		return nil
+	}
+	frame := t.filter.Apply(screen)
+	if t.drawn && *frame == t.last {
+		return nil
+	}
+	var buf bytes.Buffer
+	if !t.drawn { // erase messages printed before the first frame
+		buf.WriteString("\x1b[2J")
+	}
+	buf.WriteString("\x1b[H")
+	t.last, t.drawn = *frame, true
+	if t.mode == TERM_BRAILLE {
+		t.renderBraille(&buf, frame)
+	} else {
+		t.renderHalfBlock(&buf, frame)
+	}
+	buf.WriteString("\x1b[0m")
+	_, err := os.Stdout.Write(buf.Bytes())
+	return err
+}
+
+func termColor(fg Color, bg Color) string {
+	return fmt.Sprintf("\x1b[38;2;%d;%d;%dm\x1b[48;2;%d;%d;%dm", fg.R, fg.G, fg.B, bg.R, bg.G, bg.B)
+}
+
+func (t *TermDevice) renderHalfBlock(buf *bytes.Buffer, frame *Frame) {
+	for y := 0; y < ScreenHeight; y += 2 {
+		var lastTop, lastBottom Color
+		for x := 0; x < ScreenWidth; x++ {
+			top := t.palette.Shade(frame[ScreenWidth*y+x])
+			bottom := t.palette.Shade(frame[ScreenWidth*(y+1)+x])
+			if x == 0 || top != lastTop || bottom != lastBottom {
+				buf.WriteString(termColor(top, bottom))
+				lastTop, lastBottom = top, bottom
+			}
+			buf.WriteString("▀")
+		}
+		buf.WriteString("\x1b[0m\r\n")
+	}
+}
+
+// braille dot bits of 2x4 cell, indexed by [y][x]
+var brailleDots = [4][2]rune{
+	{0x01, 0x08},
+	{0x02, 0x10},
+	{0x04, 0x20},
+	{0x40, 0x80},
+}
+
+// renderBraille draw dots of fading pixels while their intensity is over half, since dots cannot be shaded
+func (t *TermDevice) renderBraille(buf *bytes.Buffer, frame *Frame) {
+	for y := 0; y < ScreenHeight; y += 4 {
+		buf.WriteString(termColor(t.palette.Foreground, t.palette.Background))
+		for x := 0; x < ScreenWidth; x += 2 {
+			cell := rune(0x2800)
+			for dy := 0; dy < 4; dy++ {
+				for dx := 0; dx < 2; dx++ {
+					if frame[ScreenWidth*(y+dy)+x+dx] > maxIntensity/2 {
+						cell |= brailleDots[dy][dx]
+					}
+				}
+			}
+			buf.WriteRune(cell)
+		}
+		buf.WriteString("\x1b[0m\r\n")
+	}
+}
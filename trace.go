@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Tracer writes executed instructions and stops VM at breakpoints.
+// addresses are shown with source lines if source map is available
+type Tracer struct {
+	writer      io.Writer // nil if tracing is disabled
+	sourceMap   *SourceMap
+	breakpoints map[uint16]bool
+	lastPC      uint16
+	stopped     bool
+}
+
+func NewTracer(writer io.Writer, sourceMap *SourceMap, breakpoints []string) (*Tracer, error) {
+	t := &Tracer{writer: writer, sourceMap: sourceMap, breakpoints: make(map[uint16]bool), lastPC: 0xFFFF}
+	for _, location := range breakpoints {
+		addr, err := sourceMap.Resolve(location)
+		if err != nil {
+			return nil, err
+		}
+		t.breakpoints[addr] = true
+	}
+	return t, nil
+}
+
+// Location format address with source line if exists
+func (t *Tracer) Location(addr uint16) string {
+	if entry := t.sourceMap.Lookup(addr); entry != nil {
+		return fmt.Sprintf("0x%03X (%s: %s)", addr, entry, entry.Source)
+	}
+	return fmt.Sprintf("0x%03X", addr)
+}
+
+// Stopped reports whether VM is stopped at breakpoint
+func (t *Tracer) Stopped() bool {
+	return t.stopped
+}
+
+// Hook is passed to Chip8VM.SetHook
+func (t *Tracer) Hook(vm *Chip8VM) bool {
+	if t.breakpoints[vm.pc] {
+		t.stopped = true
+		return false
+	}
+	if t.writer == nil || vm.pc == t.lastPC { // skip busy wait (wait key or halt loop)
+		return true
+	}
+	t.lastPC = vm.pc
+
+	text := "???"
+	if ins, ok := decodeInstruction(vm.ram[Chip8ProgStartAddr:], vm.pc); ok {
+		sb := strings.Builder{}
+		_ = ins.Print(InstructionPrinter{writer: &sb})
+		text = strings.TrimSpace(sb.String())
+	}
+	line := fmt.Sprintf("0x%03X  %02X%02X  %-20s", vm.pc, vm.ram[vm.pc], vm.ram[(vm.pc+1)%Chip8RAMSize], text)
+	if entry := t.sourceMap.Lookup(vm.pc); entry != nil {
+		line += fmt.Sprintf("  ; %s: %s", entry, entry.Source)
+	}
+	_, _ = fmt.Fprintln(t.writer, strings.TrimRight(line, " "))
+	return true
+}
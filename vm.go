@@ -16,7 +16,7 @@ const (
 	KeyNum       = 16
 )
 
-type Screen = [ScreenWidth * ScreenHeight]byte
+type Screen [ScreenWidth * ScreenHeight]byte
 
 var fontSpriteSet = [80]uint8{
 	0xF0, 0x90, 0x90, 0x90, 0xF0, // 0
@@ -81,6 +81,7 @@ type Chip8VM struct {
 	waitKeyReleased bool
 	waitingKey      uint8
 	device          Device
+	hook            func(vm *Chip8VM) bool
 }
 
 func NewChip8VM(reader io.Reader, device Device) (*Chip8VM, error) {
@@ -128,6 +129,17 @@ func (vm *Chip8VM) Dump(writer io.Writer) {
 	_, _ = fmt.Fprintf(writer, "DT=%d, ST=%d\n", vm.dt, vm.st)
 }
 
+// SetHook set function called before each instruction. VM stops if the hook returns false
+func (vm *Chip8VM) SetHook(hook func(vm *Chip8VM) bool) {
+	vm.hook = hook
+}
+
+// DumpMemory write whole RAM image
+func (vm *Chip8VM) DumpMemory(writer io.Writer) error {
+	_, err := writer.Write(vm.ram[:])
+	return err
+}
+
 const timerCountMicroSec = 16667
 
 // Run entry point
@@ -137,6 +149,9 @@ func (vm *Chip8VM) Run() error {
 		if !vm.device.PollKey(&vm.keypad) {
 			return nil
 		}
+		if vm.hook != nil && !vm.hook(vm) {
+			return nil
+		}
 		vm.dispatchSingleIns()
 		if err := vm.device.Draw(&vm.screen); err != nil {
 			return err
@@ -214,33 +229,35 @@ func (vm *Chip8VM) dispatchSingleIns() {
 		vm.reg[r1] &= vm.reg[r2]
 	case OP_8XY3:
 		vm.reg[r1] ^= vm.reg[r2]
-	case OP_8XY4:
-		if vm.reg[r1] > uint8(math.MaxUint8)-vm.reg[r1] { // overflow
-			vm.reg[0xF] = 1
-		} else {
-			vm.reg[0xF] = 0
+	case OP_8XY4: // flag must be set after the result, since Vx may be VF
+		flag := uint8(0)
+		if vm.reg[r1] > uint8(math.MaxUint8)-vm.reg[r2] { // overflow
+			flag = 1
 		}
 		vm.reg[r1] += vm.reg[r2]
+		vm.reg[0xF] = flag
 	case OP_8XY5:
+		flag := uint8(1)
 		if vm.reg[r1] < vm.reg[r2] { // underflow
-			vm.reg[0xF] = 0
-		} else {
-			vm.reg[0xF] = 1
+			flag = 0
 		}
 		vm.reg[r1] -= vm.reg[r2]
+		vm.reg[0xF] = flag
 	case OP_8XY6:
-		vm.reg[0xF] = vm.reg[r1] & 0x1
+		flag := vm.reg[r1] & 0x1
 		vm.reg[r1] >>= 1
+		vm.reg[0xF] = flag
 	case OP_8XY7:
+		flag := uint8(1)
 		if vm.reg[r1] > vm.reg[r2] { // underflow
-			vm.reg[0xF] = 0
-		} else {
-			vm.reg[0xF] = 1
+			flag = 0
 		}
 		vm.reg[r1] = vm.reg[r2] - vm.reg[r1]
+		vm.reg[0xF] = flag
 	case OP_8XYE:
-		vm.reg[0xF] = vm.reg[r1] >> 7
+		flag := vm.reg[r1] >> 7
 		vm.reg[r1] <<= 1
+		vm.reg[0xF] = flag
 	case OP_9XY0:
 		if vm.reg[r1] != vm.reg[r2] {
 			vm.pc += 2
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// VF is written after the result, so flag wins when Vx is VF
+func TestArithmeticFlags(t *testing.T) {
+	tests := []struct {
+		name string
+		ins  uint16
+		regs map[int]uint8 // initial registers
+		want map[int]uint8
+	}{
+		{"8XY4 no carry", 0x8124, map[int]uint8{1: 0x10, 2: 0x20}, map[int]uint8{1: 0x30, 0xF: 0}},
+		{"8XY4 carry", 0x8124, map[int]uint8{1: 0xF0, 2: 0x20}, map[int]uint8{1: 0x10, 0xF: 1}},
+		{"8XY4 carry from Vy", 0x8124, map[int]uint8{1: 0x10, 2: 0xF0}, map[int]uint8{1: 0x00, 0xF: 1}},
+		{"8XY4 no carry at 255", 0x8124, map[int]uint8{1: 0x0F, 2: 0xF0}, map[int]uint8{1: 0xFF, 0xF: 0}},
+		{"8XY4 x is VF", 0x8F14, map[int]uint8{0xF: 0x80, 1: 0x90}, map[int]uint8{0xF: 1}},
+		{"8XY4 y is VF", 0x81F4, map[int]uint8{1: 0xFF, 0xF: 0x01}, map[int]uint8{1: 0x00, 0xF: 1}},
+		{"8XY5 no borrow", 0x8125, map[int]uint8{1: 5, 2: 3}, map[int]uint8{1: 2, 0xF: 1}},
+		{"8XY5 equal", 0x8125, map[int]uint8{1: 5, 2: 5}, map[int]uint8{1: 0, 0xF: 1}},
+		{"8XY5 borrow", 0x8125, map[int]uint8{1: 3, 2: 5}, map[int]uint8{1: 0xFE, 0xF: 0}},
+		{"8XY5 x is VF", 0x8F15, map[int]uint8{0xF: 5, 1: 3}, map[int]uint8{0xF: 1}},
+		{"8XY5 y is VF", 0x81F5, map[int]uint8{1: 3, 0xF: 5}, map[int]uint8{1: 0xFE, 0xF: 0}},
+		{"8XY6 shift out 1", 0x8126, map[int]uint8{1: 0x05}, map[int]uint8{1: 0x02, 0xF: 1}},
+		{"8XY6 shift out 0", 0x8126, map[int]uint8{1: 0x04}, map[int]uint8{1: 0x02, 0xF: 0}},
+		{"8XY6 x is VF", 0x8F16, map[int]uint8{0xF: 0x05}, map[int]uint8{0xF: 1}},
+		{"8XY7 no borrow", 0x8127, map[int]uint8{1: 3, 2: 5}, map[int]uint8{1: 2, 0xF: 1}},
+		{"8XY7 borrow", 0x8127, map[int]uint8{1: 5, 2: 3}, map[int]uint8{1: 0xFE, 0xF: 0}},
+		{"8XY7 x is VF", 0x8F17, map[int]uint8{0xF: 3, 1: 5}, map[int]uint8{0xF: 1}},
+		{"8XY7 y is VF", 0x81F7, map[int]uint8{1: 5, 0xF: 3}, map[int]uint8{1: 0xFE, 0xF: 0}},
+		{"8XYE shift out 1", 0x812E, map[int]uint8{1: 0x81}, map[int]uint8{1: 0x02, 0xF: 1}},
+		{"8XYE shift out 0", 0x812E, map[int]uint8{1: 0x41}, map[int]uint8{1: 0x82, 0xF: 0}},
+		{"8XYE x is VF", 0x8F1E, map[int]uint8{0xF: 0x81}, map[int]uint8{0xF: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vm, err := NewChip8VM(bytes.NewReader([]byte{byte(tt.ins >> 8), byte(tt.ins)}), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for r, v := range tt.regs {
+				vm.reg[r] = v
+			}
+			vm.dispatchSingleIns()
+			for r, v := range tt.want {
+				if vm.reg[r] != v {
+					t.Errorf("V%X = 0x%02X, want 0x%02X", r, vm.reg[r], v)
+				}
+			}
+		})
+	}
+}
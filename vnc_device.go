@@ -0,0 +1,464 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"net"
+	"strings"
+	"sync"
+)
+
+/*
+VNC frontend serving the screen by RFB protocol (RFC 6143) with security type None and Raw encoding.
+framebuffer is rendered by post-processing pipeline at capture scale, and each client receives
+only the tiles changed since its last update
+*/
+
+const (
+	defaultVNCHost = "127.0.0.1" // no authentication, so listen publicly only by explicit host
+	defaultVNCPort = "5900"
+	vncTileSize    = 16
+	vncName        = "octochip"
+)
+
+// RFB client message types
+const (
+	VNC_SET_PIXEL_FORMAT = 0
+	VNC_SET_ENCODINGS    = 2
+	VNC_UPDATE_REQUEST   = 3
+	VNC_KEY_EVENT        = 4
+	VNC_POINTER_EVENT    = 5
+	VNC_CLIENT_CUT_TEXT  = 6
+)
+
+// RFB server message type
+const VNC_FRAMEBUFFER_UPDATE = 0
+
+type vncPixelFormat struct {
+	BitsPerPixel uint8
+	Depth        uint8
+	BigEndian    uint8
+	TrueColor    uint8
+	RedMax       uint16
+	GreenMax     uint16
+	BlueMax      uint16
+	RedShift     uint8
+	GreenShift   uint8
+	BlueShift    uint8
+	_            [3]byte
+}
+
+// server pixel format is 32 bit little endian xRGB
+var vncDefaultPixelFormat = vncPixelFormat{
+	BitsPerPixel: 32, Depth: 24, TrueColor: 1,
+	RedMax: 255, GreenMax: 255, BlueMax: 255, RedShift: 16, GreenShift: 8, BlueShift: 0,
+}
+
+// appendPixel append the color encoded in the pixel format
+func (f *vncPixelFormat) appendPixel(buf []byte, r uint8, g uint8, b uint8) []byte {
+	value := uint32(r)*uint32(f.RedMax)/255<<f.RedShift |
+		uint32(g)*uint32(f.GreenMax)/255<<f.GreenShift |
+		uint32(b)*uint32(f.BlueMax)/255<<f.BlueShift
+	var order binary.AppendByteOrder = binary.LittleEndian
+	if f.BigEndian != 0 {
+		order = binary.BigEndian
+	}
+	switch f.BitsPerPixel {
+	case 8:
+		return append(buf, byte(value))
+	case 16:
+		return order.AppendUint16(buf, uint16(value))
+	default:
+		return order.AppendUint32(buf, value)
+	}
+}
+
+// keysyms of X11 other than Latin-1 characters, to key names of Keymap
+var vncKeysymNames = map[uint32]string{
+	0x0020: "space",
+	0xFF1B: "escape",
+	0xFF0D: "enter",
+	0xFF09: "tab",
+	0xFF08: "backspace",
+	0xFF51: "left",
+	0xFF52: "up",
+	0xFF53: "right",
+	0xFF54: "down",
+	0xFFAF: "kp/",
+	0xFFAA: "kp*",
+	0xFFAD: "kp-",
+	0xFFAB: "kp+",
+	0xFFAE: "kp.",
+	0xFF8D: "kpenter",
+}
+
+// vncKeyName convert keysym to key name of Keymap
+func vncKeyName(keysym uint32) (string, bool) {
+	if name, ok := vncKeysymNames[keysym]; ok {
+		return name, true
+	}
+	switch {
+	case keysym >= 0xFFB0 && keysym <= 0xFFB9:
+		return fmt.Sprintf("kp%d", keysym-0xFFB0), true
+	case keysym >= 0xFFBE && keysym <= 0xFFC9:
+		return fmt.Sprintf("f%d", keysym-0xFFBE+1), true
+	case (keysym > 0x20 && keysym <= 0x7E) || (keysym >= 0xA0 && keysym <= 0xFF):
+		return strings.ToLower(string(rune(keysym))), true
+	case keysym&0xFF000000 == 0x01000000: // Unicode keysym
+		return strings.ToLower(string(rune(keysym & 0x00FFFFFF))), true
+	}
+	return "", false
+}
+
+type vncClient struct {
+	conn      net.Conn
+	format    vncPixelFormat
+	requested bool        // client waits for update
+	full      bool        // non-incremental update is requested
+	sent      *image.RGBA // framebuffer as the client knows
+	version   int         // version of framebuffer compared with sent
+	closed    bool
+}
+
+type VNCDevice struct {
+	listen      string
+	filter      *DisplayFilter
+	pipeline    *Pipeline
+	scale       int
+	ticker      frameTicker
+	pacer       instructionPacer
+	listener    net.Listener
+	keys        remoteKeys
+	mutex       sync.Mutex
+	cond        *sync.Cond // signaled when framebuffer or requests are changed
+	framebuffer *image.RGBA
+	version     int
+	clients     map[*vncClient]bool
+	shutdown    bool
+}
+
+// NewVNCDevice create VNC frontend. listen is PORT or HOST:PORT (default: 127.0.0.1:5900).
+// use 0.0.0.0:PORT to listen on all interfaces
+func NewVNCDevice(listen string, options FrontendOptions) *VNCDevice {
+	if listen == "" {
+		listen = defaultVNCPort
+	}
+	if !strings.Contains(listen, ":") {
+		listen = ":" + listen
+	}
+	if strings.HasPrefix(listen, ":") {
+		listen = defaultVNCHost + listen
+	}
+	device := &VNCDevice{
+		listen:   listen,
+		filter:   NewDisplayFilter(options.Display),
+		pipeline: NewPipeline(options.Display),
+		scale:    captureScale(options.Display),
+		pacer:    instructionPacer{rate: defaultInstructionRate},
+		keys:     newRemoteKeys(options.Keymap),
+		clients:  make(map[*vncClient]bool),
+	}
+	device.cond = sync.NewCond(&device.mutex)
+	device.framebuffer = image.NewRGBA(device.pipeline.Render(&Frame{}, device.scale).Rect)
+	return device
+}
+
+func (v *VNCDevice) Setup() error {
+	listener, err := net.Listen("tcp", v.listen)
+	if err != nil {
+		return err
+	}
+	v.listener = listener
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go v.serve(conn)
+		}
+	}()
+	fmt.Printf("VNC server listening on %s\n", listener.Addr())
+	return nil
+}
+
+func (v *VNCDevice) Teardown() {
+	if v.listener == nil {
+		return
+	}
+	_ = v.listener.Close()
+	v.listener = nil
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.shutdown = true
+	for client := range v.clients {
+		client.closed = true
+		_ = client.conn.Close()
+	}
+	v.cond.Broadcast()
+}
+
+// handshake negotiate protocol version and security type None, then exchange init messages
+func (v *VNCDevice) handshake(conn net.Conn, reader *bufio.Reader) error {
+	if _, err := io.WriteString(conn, "RFB 003.008\n"); err != nil {
+		return err
+	}
+	var version [12]byte
+	if _, err := io.ReadFull(reader, version[:]); err != nil {
+		return err
+	}
+	var major, minor int
+	if _, err := fmt.Sscanf(string(version[:]), "RFB %03d.%03d\n", &major, &minor); err != nil || major != 3 {
+		return fmt.Errorf("unsupported protocol version: %q", version)
+	}
+	if minor < 7 { // 3.3: server decides security type
+		if err := binary.Write(conn, binary.BigEndian, uint32(1)); err != nil {
+			return err
+		}
+	} else {
+		if _, err := conn.Write([]byte{1, 1}); err != nil { // 1 security type: None
+			return err
+		}
+		securityType, err := reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		if securityType != 1 {
+			return fmt.Errorf("unsupported security type: %d", securityType)
+		}
+		if minor >= 8 { // SecurityResult OK
+			if err := binary.Write(conn, binary.BigEndian, uint32(0)); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := reader.ReadByte(); err != nil { // ClientInit (shared flag). clients are always shared
+		return err
+	}
+	var init bytes.Buffer
+	_ = binary.Write(&init, binary.BigEndian, uint16(v.framebuffer.Rect.Dx()))
+	_ = binary.Write(&init, binary.BigEndian, uint16(v.framebuffer.Rect.Dy()))
+	_ = binary.Write(&init, binary.BigEndian, vncDefaultPixelFormat)
+	_ = binary.Write(&init, binary.BigEndian, uint32(len(vncName)))
+	init.WriteString(vncName)
+	_, err := conn.Write(init.Bytes())
+	return err
+}
+
+func (v *VNCDevice) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	if err := v.handshake(conn, reader); err != nil {
+		fmt.Printf("VNC handshake error: %s: %v\n", conn.RemoteAddr(), err)
+		return
+	}
+	client := &vncClient{conn: conn, format: vncDefaultPixelFormat, version: -1}
+	v.mutex.Lock()
+	if v.shutdown {
+		v.mutex.Unlock()
+		return
+	}
+	client.sent = image.NewRGBA(v.framebuffer.Rect)
+	v.clients[client] = true
+	v.mutex.Unlock()
+	fmt.Printf("VNC client connected: %s\n", conn.RemoteAddr())
+	go v.sendUpdates(client)
+
+	held := make(map[string]bool)
+	err := v.readMessages(client, reader, held)
+	v.keys.releaseAll(held)
+	v.mutex.Lock()
+	client.closed = true
+	delete(v.clients, client)
+	v.cond.Broadcast()
+	v.mutex.Unlock()
+	if err != nil && err != io.EOF {
+		fmt.Printf("VNC client error: %s: %v\n", conn.RemoteAddr(), err)
+	}
+	fmt.Printf("VNC client disconnected: %s\n", conn.RemoteAddr())
+}
+
+func (v *VNCDevice) readMessages(client *vncClient, reader *bufio.Reader, held map[string]bool) error {
+	for {
+		messageType, err := reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch messageType {
+		case VNC_SET_PIXEL_FORMAT:
+			var message struct {
+				_      [3]byte
+				Format vncPixelFormat
+			}
+			if err := binary.Read(reader, binary.BigEndian, &message); err != nil {
+				return err
+			}
+			format := message.Format
+			if format.TrueColor == 0 || (format.BitsPerPixel != 8 && format.BitsPerPixel != 16 && format.BitsPerPixel != 32) {
+				return fmt.Errorf("unsupported pixel format: %+v", format)
+			}
+			v.mutex.Lock()
+			client.format, client.full = format, true
+			v.mutex.Unlock()
+		case VNC_SET_ENCODINGS: // Raw is always used
+			var message struct {
+				_     byte
+				Count uint16
+			}
+			if err := binary.Read(reader, binary.BigEndian, &message); err != nil {
+				return err
+			}
+			if _, err := reader.Discard(int(message.Count) * 4); err != nil {
+				return err
+			}
+		case VNC_UPDATE_REQUEST: // requested region is ignored, since changed tiles are sent
+			var message struct {
+				Incremental         uint8
+				X, Y, Width, Height uint16
+			}
+			if err := binary.Read(reader, binary.BigEndian, &message); err != nil {
+				return err
+			}
+			v.mutex.Lock()
+			client.requested = true
+			client.full = client.full || message.Incremental == 0
+			v.cond.Broadcast()
+			v.mutex.Unlock()
+		case VNC_KEY_EVENT:
+			var message struct {
+				Down   uint8
+				_      [2]byte
+				Keysym uint32
+			}
+			if err := binary.Read(reader, binary.BigEndian, &message); err != nil {
+				return err
+			}
+			if name, ok := vncKeyName(message.Keysym); ok {
+				v.keys.send(held, name, message.Down != 0)
+			}
+		case VNC_POINTER_EVENT:
+			if _, err := reader.Discard(5); err != nil {
+				return err
+			}
+		case VNC_CLIENT_CUT_TEXT:
+			var message struct {
+				_      [3]byte
+				Length uint32
+			}
+			if err := binary.Read(reader, binary.BigEndian, &message); err != nil {
+				return err
+			}
+			if _, err := reader.Discard(int(message.Length)); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown message type: %d", messageType)
+		}
+	}
+}
+
+// sendUpdates wait for update request, then send changed tiles when framebuffer is changed
+func (v *VNCDevice) sendUpdates(client *vncClient) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	for {
+		for !client.closed && !(client.requested && (client.full || client.version != v.version)) {
+			v.cond.Wait()
+		}
+		if client.closed {
+			return
+		}
+		client.version = v.version
+		message := v.encodeUpdate(client)
+		if message == nil {
+			continue
+		}
+		client.requested, client.full = false, false
+		v.mutex.Unlock()
+		_, err := client.conn.Write(message)
+		v.mutex.Lock()
+		if err != nil {
+			client.closed = true
+			_ = client.conn.Close()
+			return
+		}
+	}
+}
+
+// encodeUpdate returns FramebufferUpdate of tiles differ from the client's framebuffer, or nil if nothing is changed.
+// changed tiles in a row are merged into one rectangle. mutex must be locked
+func (v *VNCDevice) encodeUpdate(client *vncClient) []byte {
+	width, height := v.framebuffer.Rect.Dx(), v.framebuffer.Rect.Dy()
+	var rects []image.Rectangle
+	for y := 0; y < height; y += vncTileSize {
+		start := -1
+		for x := 0; x <= width; x += vncTileSize {
+			tile := image.Rect(x, y, min(x+vncTileSize, width), min(y+vncTileSize, height))
+			changed := x < width && (client.full || !sameRegion(v.framebuffer, client.sent, tile))
+			if changed && start < 0 {
+				start = x
+			} else if !changed && start >= 0 {
+				rects = append(rects, image.Rect(start, y, x, tile.Max.Y))
+				start = -1
+			}
+		}
+	}
+	if len(rects) == 0 {
+		return nil
+	}
+	message := []byte{VNC_FRAMEBUFFER_UPDATE, 0}
+	message = binary.BigEndian.AppendUint16(message, uint16(len(rects)))
+	for _, rect := range rects {
+		message = binary.BigEndian.AppendUint16(message, uint16(rect.Min.X))
+		message = binary.BigEndian.AppendUint16(message, uint16(rect.Min.Y))
+		message = binary.BigEndian.AppendUint16(message, uint16(rect.Dx()))
+		message = binary.BigEndian.AppendUint16(message, uint16(rect.Dy()))
+		message = binary.BigEndian.AppendUint32(message, 0) // Raw encoding
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			row := v.framebuffer.Pix[v.framebuffer.PixOffset(rect.Min.X, y):v.framebuffer.PixOffset(rect.Max.X, y)]
+			for i := 0; i < len(row); i += 4 {
+				message = client.format.appendPixel(message, row[i], row[i+1], row[i+2])
+			}
+			copy(client.sent.Pix[client.sent.PixOffset(rect.Min.X, y):], row)
+		}
+	}
+	return message
+}
+
+func sameRegion(a *image.RGBA, b *image.RGBA, rect image.Rectangle) bool {
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		start, end := a.PixOffset(rect.Min.X, y), a.PixOffset(rect.Max.X, y)
+		if !bytes.Equal(a.Pix[start:end], b.Pix[start:end]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Draw render filtered and post-processed frame at 60 fps, and wake up clients if it is changed
+func (v *VNCDevice) Draw(screen *Screen) error {
+	v.pacer.wait()
+	if !v.ticker.due() {
+		return nil
+	}
+	img := v.pipeline.Render(v.filter.Apply(screen), v.scale)
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	if bytes.Equal(img.Pix, v.framebuffer.Pix) {
+		return nil
+	}
+	copy(v.framebuffer.Pix, img.Pix)
+	v.version++
+	v.cond.Broadcast()
+	return nil
+}
+
+// PollKey apply keys of clients. quit key is ignored, so viewers cannot stop the emulator
+func (v *VNCDevice) PollKey(keypad *Keypad) bool {
+	v.keys.poll(keypad)
+	return true
+}
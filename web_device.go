@@ -0,0 +1,319 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+/*
+browser frontend. page draws frames streamed over WebSocket on canvas, and sends key events back.
+the first connected client is the player, and others are spectators until the player leaves
+
+messages:
+  server -> client  binary: intensity of 64x32 pixels (Frame)
+                    text:   {"foreground": "#RRGGBB", "background": "#RRGGBB", "player": bool, "viewers": n}
+  client -> server  text:   "down <key name>" or "up <key name>" (key names of Keymap)
+*/
+
+type webClient struct {
+	conn   *wsConn
+	send   chan webMessage // frames. dropped if the queue is full
+	status chan []byte     // latest status. it is never dropped, but replaced by newer one
+}
+
+type webMessage struct {
+	opcode  byte
+	payload []byte
+}
+
+type WebDevice struct {
+	listen   string
+	palette  Palette
+	filter   *DisplayFilter
+	ticker   frameTicker
+	pacer    instructionPacer
+	server   *http.Server
+	keys     remoteKeys
+	mutex    sync.Mutex
+	clients  []*webClient // in order of connection. the first one is the player
+	last     Frame
+	sent     bool
+	shutdown bool
+}
+
+func NewWebDevice(listen string, options FrontendOptions) *WebDevice {
+	if listen == "" {
+		listen = defaultWebListen
+	}
+	return &WebDevice{
+		listen:  listen,
+		palette: options.Display.Palette,
+		filter:  NewDisplayFilter(options.Display),
+		pacer:   instructionPacer{rate: defaultInstructionRate},
+		keys:    newRemoteKeys(options.Keymap),
+	}
+}
+
+const (
+	defaultWebListen = "127.0.0.1:8080"
+	webSendQueue     = 4 // frames are dropped for slow clients
+)
+
+func (w *WebDevice) Setup() error {
+	listener, err := net.Listen("tcp", w.listen)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(writer http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(writer, r)
+			return
+		}
+		writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = io.WriteString(writer, webPage)
+	})
+	mux.HandleFunc("/ws", w.serveWebSocket)
+	w.server = &http.Server{Handler: mux}
+	go func() {
+		if err := w.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			fmt.Printf("serve error: %v\n", err)
+		}
+	}()
+	fmt.Printf("serving on http://%s/\n", listener.Addr())
+	return nil
+}
+
+func (w *WebDevice) Teardown() {
+	if w.server == nil {
+		return
+	}
+	_ = w.server.Close()
+	w.server = nil
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.shutdown = true
+	for _, client := range w.clients { // hijacked connections are not closed by server
+		_ = client.conn.Close()
+	}
+}
+
+func (w *WebDevice) serveWebSocket(writer http.ResponseWriter, r *http.Request) {
+	conn, err := upgradeWebSocket(writer, r)
+	if err != nil {
+		return
+	}
+	client := &webClient{conn: conn, send: make(chan webMessage, webSendQueue), status: make(chan []byte, 1)}
+	if !w.join(client) {
+		_ = conn.Close()
+		return
+	}
+	fmt.Printf("client connected: %s\n", r.RemoteAddr)
+	go func() {
+		for {
+			var message webMessage
+			select {
+			case status := <-client.status:
+				message = webMessage{opcode: WS_OP_TEXT, payload: status}
+			case frame, ok := <-client.send:
+				if !ok {
+					return
+				}
+				message = frame
+			}
+			if err := conn.WriteMessage(message.opcode, message.payload); err != nil {
+				_ = conn.Close()
+				return
+			}
+		}
+	}()
+
+	held := make(map[string]bool)
+	for {
+		opcode, payload, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+		if opcode != WS_OP_TEXT || !w.isPlayer(client) {
+			continue
+		}
+		action, name, _ := strings.Cut(string(payload), " ")
+		switch action {
+		case "down", "up":
+			w.keys.send(held, name, action == "down")
+		}
+	}
+	w.keys.releaseAll(held)
+	w.leave(client)
+	_ = conn.Close()
+	fmt.Printf("client disconnected: %s\n", r.RemoteAddr)
+}
+
+// join add the client and send current state to it
+func (w *WebDevice) join(client *webClient) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.shutdown {
+		return false
+	}
+	w.clients = append(w.clients, client)
+	if w.sent {
+		frame := w.last
+		w.enqueue(client, webMessage{opcode: WS_OP_BINARY, payload: frame[:]})
+	}
+	w.broadcastStatus()
+	return true
+}
+
+func (w *WebDevice) leave(client *webClient) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	index := slices.Index(w.clients, client)
+	if index < 0 {
+		return
+	}
+	w.clients = slices.Delete(w.clients, index, index+1)
+	close(client.send)
+	w.broadcastStatus()
+}
+
+func (w *WebDevice) isPlayer(client *webClient) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return len(w.clients) > 0 && w.clients[0] == client
+}
+
+// broadcastStatus send palette and role to each client. mutex must be locked
+func (w *WebDevice) broadcastStatus() {
+	for i, client := range w.clients {
+		status, _ := json.Marshal(map[string]any{
+			"foreground": w.palette.Foreground.String(),
+			"background": w.palette.Background.String(),
+			"player":     i == 0,
+			"viewers":    len(w.clients),
+		})
+		select { // replace status not sent yet
+		case <-client.status:
+		default:
+		}
+		client.status <- status
+	}
+}
+
+// enqueue send frame without blocking VM. frame is dropped if the client is too slow
+func (w *WebDevice) enqueue(client *webClient, message webMessage) {
+	select {
+	case client.send <- message:
+	default:
+	}
+}
+
+// Draw stream filtered frame to clients at 60 fps if it is changed
+func (w *WebDevice) Draw(screen *Screen) error {
+	w.pacer.wait()
+	if !w.ticker.due() {
+		return nil
+	}
+	frame := w.filter.Apply(screen)
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.sent && *frame == w.last {
+		return nil
+	}
+	w.last, w.sent = *frame, true
+	payload := w.last // shared by clients, so copy it
+	for _, client := range w.clients {
+		w.enqueue(client, webMessage{opcode: WS_OP_BINARY, payload: payload[:]})
+	}
+	return nil
+}
+
+// PollKey apply keys of the player. quit key is ignored
+func (w *WebDevice) PollKey(keypad *Keypad) bool {
+	w.keys.poll(keypad)
+	return true
+}
+
+const webPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>octochip</title>
+<style>
+  body { margin: 0; background: #000; color: #ccc; font-family: sans-serif; display: flex; flex-direction: column; align-items: center; }
+  canvas { width: 100vw; max-width: calc(200vh - 4em); aspect-ratio: 2 / 1; image-rendering: pixelated; }
+  #status { padding: 0.5em; }
+</style>
+</head>
+<body>
+<canvas id="screen" width="64" height="32"></canvas>
+<div id="status">connecting...</div>
+<script>
+const canvas = document.getElementById("screen");
+const context = canvas.getContext("2d");
+const image = context.createImageData(64, 32);
+const statusLine = document.getElementById("status");
+let foreground = [255, 255, 255], background = [0, 0, 0], player = false, frame = null;
+
+function parseColor(hex) {
+  return [1, 3, 5].map(i => parseInt(hex.substr(i, 2), 16));
+}
+
+function draw() {
+  if (!frame) return;
+  for (let i = 0; i < frame.length; i++) {
+    for (let c = 0; c < 3; c++) {
+      image.data[i * 4 + c] = (background[c] * (255 - frame[i]) + foreground[c] * frame[i]) / 255;
+    }
+    image.data[i * 4 + 3] = 255;
+  }
+  context.putImageData(image, 0, 0);
+}
+
+// key names of Keymap
+function keyName(event) {
+  const numpad = {NumpadDivide: "kp/", NumpadMultiply: "kp*", NumpadSubtract: "kp-", NumpadAdd: "kp+", NumpadDecimal: "kp.", NumpadEnter: "kpenter"};
+  if (numpad[event.code]) return numpad[event.code];
+  if (/^Numpad[0-9]$/.test(event.code)) return "kp" + event.code.substr(6);
+  const special = {ArrowUp: "up", ArrowDown: "down", ArrowLeft: "left", ArrowRight: "right", " ": "space",
+    Enter: "enter", Escape: "escape", Tab: "tab", Backspace: "backspace"};
+  if (special[event.key]) return special[event.key];
+  if (/^F[0-9]+$/.test(event.key)) return event.key.toLowerCase();
+  if ([...event.key].length === 1) return event.key.toLowerCase();
+  return null;
+}
+
+const socket = new WebSocket((location.protocol === "https:" ? "wss://" : "ws://") + location.host + "/ws");
+socket.binaryType = "arraybuffer";
+socket.onmessage = event => {
+  if (typeof event.data === "string") {
+    const message = JSON.parse(event.data);
+    foreground = parseColor(message.foreground);
+    background = parseColor(message.background);
+    player = message.player;
+    statusLine.textContent = (player ? "player" : "spectator") + " / " + message.viewers + " connected";
+  } else {
+    frame = new Uint8Array(event.data);
+  }
+  draw();
+};
+socket.onclose = () => { statusLine.textContent = "disconnected"; };
+
+function sendKey(event, action) {
+  const name = keyName(event);
+  if (!player || !name || socket.readyState !== WebSocket.OPEN) return;
+  event.preventDefault();
+  if (!event.repeat) socket.send(action + " " + name);
+}
+document.addEventListener("keydown", event => sendKey(event, "down"));
+document.addEventListener("keyup", event => sendKey(event, "up"));
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSameOrigin(t *testing.T) {
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"", true},
+		{"http://127.0.0.1:8080", true},
+		{"https://127.0.0.1:8080", true},
+		{"http://evil.example", false},
+		{"http://127.0.0.1:9000", false},
+		{"null", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "http://127.0.0.1:8080/ws", nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if got := sameOrigin(r); got != tt.want {
+			t.Errorf("origin %q: got %v, want %v", tt.origin, got, tt.want)
+		}
+	}
+}
+
+func TestWebStatusIsNotDropped(t *testing.T) {
+	w := NewWebDevice("", FrontendOptions{})
+	newClient := func() *webClient {
+		return &webClient{send: make(chan webMessage, webSendQueue), status: make(chan []byte, 1)}
+	}
+	player, spectator := newClient(), newClient()
+	w.clients = []*webClient{player, spectator}
+	for range webSendQueue { // slow spectator
+		w.enqueue(spectator, webMessage{opcode: WS_OP_BINARY})
+	}
+	w.mutex.Lock()
+	w.broadcastStatus()
+	w.mutex.Unlock()
+	w.leave(player)
+
+	var status struct {
+		Player  bool `json:"player"`
+		Viewers int  `json:"viewers"`
+	}
+	select {
+	case payload := <-spectator.status:
+		if err := json.Unmarshal(payload, &status); err != nil {
+			t.Fatal(err)
+		}
+	default:
+		t.Fatal("status is dropped")
+	}
+	if !status.Player || status.Viewers != 1 {
+		t.Errorf("got %+v, want the latest status as the player", status)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+/*
+minimal WebSocket (RFC 6455) server connection. extensions and subprotocols are not supported
+*/
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	WS_OP_CONTINUATION = 0x0
+	WS_OP_TEXT         = 0x1
+	WS_OP_BINARY       = 0x2
+	WS_OP_CLOSE        = 0x8
+	WS_OP_PING         = 0x9
+	WS_OP_PONG         = 0xA
+)
+
+const wsMaxMessageSize = 1 << 16
+
+type wsConn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	writeMu sync.Mutex
+}
+
+func headerContains(header http.Header, name string, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, v := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sameOrigin reports whether the page which opened WebSocket is served by this server.
+// clients other than browsers do not send Origin
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// upgradeWebSocket complete opening handshake and take over the connection
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet || !headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") || key == "" {
+		http.Error(w, "websocket handshake is required", http.StatusBadRequest)
+		return nil, fmt.Errorf("not websocket handshake")
+	}
+	if !sameOrigin(r) {
+		http.Error(w, "cross-origin websocket is not allowed", http.StatusForbidden)
+		return nil, fmt.Errorf("cross-origin request: %s", r.Header.Get("Origin"))
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return nil, fmt.Errorf("unsupported websocket version")
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket is not supported", http.StatusInternalServerError)
+		return nil, fmt.Errorf("connection cannot be hijacked")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	digest := sha1.Sum([]byte(key + websocketGUID))
+	_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(digest[:]) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, reader: rw.Reader}, nil
+}
+
+// readFrame returns a frame. payload of client frame is always masked
+func (c *wsConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err = io.ReadFull(c.reader, header[:]); err != nil {
+		return
+	}
+	fin, opcode = header[0]&0x80 != 0, header[0]&0x0F
+	if header[1]&0x80 == 0 {
+		return fin, opcode, nil, fmt.Errorf("unmasked client frame")
+	}
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.reader, ext[:]); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.reader, ext[:]); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > wsMaxMessageSize {
+		return fin, opcode, nil, fmt.Errorf("too large frame: %d bytes", length)
+	}
+	var mask [4]byte
+	if _, err = io.ReadFull(c.reader, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.reader, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// ReadMessage returns next text or binary message. ping is answered, and io.EOF is returned after close
+func (c *wsConn) ReadMessage() (byte, []byte, error) {
+	var opcode byte
+	var message []byte
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch op {
+		case WS_OP_PING:
+			if err := c.WriteMessage(WS_OP_PONG, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case WS_OP_PONG:
+			continue
+		case WS_OP_CLOSE:
+			_ = c.WriteMessage(WS_OP_CLOSE, payload[:min(len(payload), 2)])
+			return 0, nil, io.EOF
+		case WS_OP_CONTINUATION:
+			if message == nil {
+				return 0, nil, errors.New("unexpected continuation frame")
+			}
+		case WS_OP_TEXT, WS_OP_BINARY:
+			opcode = op
+		default:
+			return 0, nil, fmt.Errorf("unknown opcode: %x", op)
+		}
+		message = append(message, payload...)
+		if len(message) > wsMaxMessageSize {
+			return 0, nil, fmt.Errorf("too large message: %d bytes", len(message))
+		}
+		if fin {
+			return opcode, message, nil
+		}
+	}
+}
+
+// WriteMessage write unmasked single frame message. it is safe to call from multiple goroutines
+func (c *wsConn) WriteMessage(opcode byte, payload []byte) error {
+	frame := []byte{0x80 | opcode}
+	switch length := len(payload); {
+	case length < 126:
+		frame = append(frame, byte(length))
+	case length <= 0xFFFF:
+		frame = binary.BigEndian.AppendUint16(append(frame, 126), uint16(length))
+	default:
+		frame = binary.BigEndian.AppendUint64(append(frame, 127), uint64(length))
+	}
+	frame = append(frame, payload...)
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+func (c *wsConn) Close() error {
+	return c.conn.Close()
+}